@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// BreakerConfig controls the per-unit circuit breaker. Units that keep failing
+// to connect, such as trucks parked in the workshop, are suspended and only
+// probed now and then instead of holding a worker slot every cycle.
+type BreakerConfig struct {
+	Threshold     int      `json:"threshold"`      // Consecutive connection failures that suspend a unit
+	ProbeInterval Duration `json:"probe_interval"` // Time between probes of a suspended unit
+}
+
+const (
+	defaultBreakerThreshold = 5
+	defaultProbeInterval    = 30 * time.Minute
+)
+
+// Breaker states as stored in unit_breakers.state
+const (
+	breakerClosed = "closed" // Polled every cycle
+	breakerOpen   = "open"   // Suspended, polled once per probe interval
+)
+
+// BreakerState is the saved circuit breaker of one unit. Times are Unix
+// seconds so every driver reads them back the same way.
+type BreakerState struct {
+	Unit      string
+	State     string
+	Failures  int   // Consecutive connection failures
+	OpenedAt  int64 // When the unit was suspended
+	NextProbe int64 // Earliest time a suspended unit is polled again
+}
+
+// Breakers tracks the circuit breaker of every unit and saves each change
+type Breakers struct {
+	threshold     int
+	probeInterval time.Duration
+	store         Store
+
+	mu    sync.Mutex
+	units map[string]*BreakerState
+}
+
+// newBreakers returns breakers with every unit closed, see Load
+func newBreakers(cfg BreakerConfig, store Store) *Breakers {
+	b := &Breakers{
+		threshold:     cfg.Threshold,
+		probeInterval: time.Duration(cfg.ProbeInterval),
+		store:         store,
+		units:         make(map[string]*BreakerState),
+	}
+	if b.threshold <= 0 {
+		b.threshold = defaultBreakerThreshold
+	}
+	if b.probeInterval <= 0 {
+		b.probeInterval = defaultProbeInterval
+	}
+	return b
+}
+
+// Load restores the saved breaker states from the store
+func (b *Breakers) Load(ctx context.Context) error {
+	states, err := b.store.LoadBreakers(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	suspended := 0
+	for i := range states {
+		b.units[states[i].Unit] = &states[i]
+		if states[i].State == breakerOpen {
+			suspended++
+		}
+	}
+	if suspended > 0 {
+		log.Printf("%d units have suspended polling", suspended)
+	}
+	return nil
+}
+
+// Allow reports whether a unit may be polled now. A suspended unit is let
+// through once per probe interval; the result of that poll decides whether
+// it is resumed.
+func (b *Breakers) Allow(alias string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.units[alias]
+	if !ok || st.State != breakerOpen {
+		return true
+	}
+	if now.Unix() < st.NextProbe {
+		return false
+	}
+	// Push the next probe out now so an overlapping cycle does not probe twice
+	st.NextProbe = now.Add(b.probeInterval).Unix()
+	return true
+}
+
+// Record updates a unit's breaker with the outcome of a poll
+func (b *Breakers) Record(ctx context.Context, alias string, class ErrorClass, now time.Time) {
+	b.mu.Lock()
+	st, ok := b.units[alias]
+	if !ok {
+		st = &BreakerState{Unit: alias, State: breakerClosed}
+		b.units[alias] = st
+	}
+	before := *st
+
+	switch {
+	case class == ClassGatewayFailure || class == ClassInvalidIP || class == ClassNoCredentials:
+		// The unit was never contacted, this says nothing about it
+	case isConnectionFailure(class):
+		st.Failures++
+		if st.State == breakerClosed && st.Failures >= b.threshold {
+			st.State = breakerOpen
+			st.OpenedAt = now.Unix()
+			log.Printf("Suspending polling of %s after %d consecutive connection failures", alias, st.Failures)
+		}
+		if st.State == breakerOpen {
+			st.NextProbe = now.Add(b.probeInterval).Unix()
+		}
+	case handshakeCompleted(class):
+		// The unit answered, even if the poll failed later on
+		if st.State == breakerOpen {
+			log.Printf("Resuming polling of %s", alias)
+		}
+		st.State = breakerClosed
+		st.Failures = 0
+		st.OpenedAt = 0
+		st.NextProbe = 0
+	}
+
+	changed := *st != before
+	saved := *st
+	b.mu.Unlock()
+
+	if changed {
+		err := b.store.SaveBreaker(ctx, saved)
+		if err != nil {
+			log.Printf("Failed to save breaker state of %s: %v", alias, err)
+		}
+	}
+}
+
+// handshakeCompleted reports whether a poll got through the SSH handshake.
+// Rejected logins and host key failures leave the breaker as it is: something
+// answered on the port, but it may not be the unit.
+func handshakeCompleted(class ErrorClass) bool {
+	switch class {
+	case ClassNone, ClassSessionFailure, ClassCommandNotFound, ClassCommandTimeout, ClassCommandFailed, ClassOutputTooLarge, ClassParseFailure:
+		return true
+	default:
+		return false
+	}
+}
+
+// isConnectionFailure reports whether a failure means the unit could not be reached
+func isConnectionFailure(class ErrorClass) bool {
+	switch class {
+	case ClassDNSFailure, ClassTCPTimeout, ClassConnectionRefused, ClassNetworkError:
+		return true
+	default:
+		return false
+	}
+}
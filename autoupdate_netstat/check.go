@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// checkOptions selects the units polled in check mode. Check mode polls them
+// once, prints the parsed results and stores nothing, so it can debug a single
+// unit or run as a Nagios-style check.
+type checkOptions struct {
+	ip        string // A single IP
+	units     string // Comma-separated aliases looked up in the inventory
+	hostsFile string // CSV/YAML/JSON inventory file, or one "ip [alias]" per line
+	output    string // table or json
+}
+
+func (o checkOptions) enabled() bool {
+	return o.ip != "" || o.units != "" || o.hostsFile != ""
+}
+
+// Exit codes of check mode, as used by Nagios plugins
+const (
+	exitOK       = 0
+	exitWarning  = 1
+	exitCritical = 2
+	exitUnknown  = 3
+)
+
+var checkStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// checkResult is one unit as printed by check mode
+type checkResult struct {
+	Unit           string         `json:"unit"`
+	IP             string         `json:"ip"`
+	State          string         `json:"state"` // OK, WARNING or CRITICAL
+	Status         string         `json:"status"`
+	ForeignAddress string         `json:"foreign_address"`
+	ForeignName    string         `json:"foreign_name"`
+	ErrorClass     ErrorClass     `json:"error_class,omitempty"`
+	Error          string         `json:"error,omitempty"`
+	Collector      string         `json:"collector,omitempty"` // Strategy used to list connections
+	Attempts       []Attempt      `json:"attempts"`
+	Reach          []PortReach    `json:"reachability,omitempty"`
+	Targets        []TargetResult `json:"targets"`
+	Connections    []Connection   `json:"connections"`
+}
+
+// runCheck polls the units selected by opts, prints them and returns the exit
+// code: the worst state of any unit, or UNKNOWN if the units could not be listed.
+func runCheck(stop, work context.Context, c *Collector, inventory *Inventory, opts checkOptions) int {
+	if opts.output != "table" && opts.output != "json" {
+		log.Printf("Unknown output format %q, use table or json", opts.output)
+		return exitUnknown
+	}
+
+	servers, err := checkServers(stop, inventory, opts)
+	if err != nil {
+		log.Printf("Failed to list units to check: %v", err)
+		return exitUnknown
+	}
+	if len(servers) == 0 {
+		log.Println("No units to check")
+		return exitUnknown
+	}
+
+	results := make([]PollResult, len(servers))
+	index := make(map[string]int, len(servers))
+	for i, server := range servers {
+		index[server.Alias] = i
+	}
+
+	var mu sync.Mutex
+	work = c.retry.withBudget(work)
+	runCycle(stop, servers, func(server Server) {
+		result := c.pollAndReach(work, server)
+		mu.Lock()
+		results[index[server.Alias]] = result
+		mu.Unlock()
+	})
+	if stop.Err() != nil {
+		return exitUnknown
+	}
+
+	code := exitOK
+	printed := make([]checkResult, 0, len(results))
+	for _, r := range results {
+		unitCode := checkCode(r)
+		if unitCode > code {
+			code = unitCode
+		}
+		printed = append(printed, checkResult{
+			Unit:           r.Server.Alias,
+			IP:             r.Server.IP.String,
+			State:          checkStates[unitCode],
+			Status:         r.Status,
+			ForeignAddress: r.ForeignAddress,
+			ForeignName:    r.ForeignName,
+			ErrorClass:     r.ErrorClass,
+			Error:          r.ErrorDetail,
+			Collector:      r.Strategy,
+			Attempts:       r.Attempts,
+			Reach:          r.Reach,
+			Targets:        r.Targets,
+			Connections:    r.Connections,
+		})
+	}
+
+	if opts.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(printed)
+	} else {
+		err = printCheckTable(printed)
+	}
+	if err != nil {
+		log.Printf("Failed to print results: %v", err)
+		return exitUnknown
+	}
+	return code
+}
+
+// checkCode rates one poll: a failed poll is CRITICAL, a unit whose primary
+// link is not ESTABLISHED is a WARNING
+func checkCode(r PollResult) int {
+	switch {
+	case r.ErrorClass != ClassNone:
+		return exitCritical
+	case r.Status == "ESTABLISHED":
+		return exitOK
+	default:
+		return exitWarning
+	}
+}
+
+// checkServers builds the unit list from whichever of -ip, -units and -hosts were given
+func checkServers(ctx context.Context, inventory *Inventory, opts checkOptions) ([]Server, error) {
+	var servers []Server
+	seen := make(map[string]bool)
+	add := func(server Server) {
+		if server.Alias == "" {
+			server.Alias = server.IP.String
+		}
+		if !seen[server.Alias] {
+			seen[server.Alias] = true
+			servers = append(servers, server)
+		}
+	}
+
+	if opts.ip != "" {
+		add(UnitEntry{ID: opts.ip, IP: opts.ip}.server())
+	}
+
+	if opts.hostsFile != "" {
+		hosts, err := readHostsFile(ctx, opts.hostsFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, server := range hosts {
+			add(server)
+		}
+	}
+
+	if opts.units != "" {
+		known, err := inventory.Servers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		byAlias := make(map[string]Server, len(known))
+		for _, server := range known {
+			byAlias[server.Alias] = server
+		}
+		for _, alias := range strings.Split(opts.units, ",") {
+			alias = strings.TrimSpace(alias)
+			if alias == "" {
+				continue
+			}
+			server, ok := byAlias[alias]
+			if !ok {
+				return nil, fmt.Errorf("unit %s is not in the inventory", alias)
+			}
+			add(server)
+		}
+	}
+
+	return servers, nil
+}
+
+// readHostsFile reads units from an inventory file, or from a plain list with
+// one "ip [alias]" per line. Units without an alias are named by their IP.
+func readHostsFile(ctx context.Context, path string) ([]Server, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv", ".yaml", ".yml", ".json":
+		return (&fileSource{name: path, path: path}).Servers(ctx)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hosts file: %v", err)
+	}
+	defer f.Close()
+
+	var servers []Server
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		alias := fields[0]
+		if len(fields) > 1 {
+			alias = fields[1]
+		}
+		servers = append(servers, UnitEntry{ID: alias, IP: fields[0]}.server())
+	}
+	return servers, scanner.Err()
+}
+
+// printCheckTable prints one line per unit, then the targets of the units
+// that could be polled
+func printCheckTable(results []checkResult) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "UNIT\tIP\tSTATE\tSTATUS\tFOREIGN ADDRESS\tNAME\tCONNECTIONS\tATTEMPTS\tREACH\tERROR")
+	for _, r := range results {
+		errText := ""
+		if r.ErrorClass != ClassNone {
+			errText = string(r.ErrorClass) + ": " + r.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", r.Unit, r.IP, r.State, r.Status, r.ForeignAddress, r.ForeignName, len(r.Connections), len(r.Attempts), formatReach(r.Reach), errText)
+	}
+
+	header := true
+	for _, r := range results {
+		for _, t := range r.Targets {
+			if header {
+				fmt.Fprintln(tw)
+				fmt.Fprintln(tw, "UNIT\tTARGET\tSTATUS\tFOREIGN ADDRESS\tNAME\tSTATES")
+				header = false
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Unit, t.Target, t.Status, t.ForeignAddress, t.ForeignName, formatStates(t.States))
+		}
+	}
+	return tw.Flush()
+}
+
+// formatStates lists connection counts as "ESTABLISHED=1 CLOSE_WAIT=12",
+// strongest state first
+func formatStates(counts map[string]int) string {
+	var parts []string
+	for _, state := range tcpStatePrecedence {
+		if n := counts[state]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", state, n))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatReach lists probed ports as "22:0.8ms 502:connection_refused"
+func formatReach(reach []PortReach) string {
+	var parts []string
+	for _, p := range reach {
+		if p.Open {
+			parts = append(parts, fmt.Sprintf("%d:%.1fms", p.Port, p.LatencyMs))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d:%s", p.Port, p.Error))
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Config holds collector settings read from a JSON file
+type Config struct {
+	Database        DatabaseConfig    `json:"database"`
+	Inventory       []InventoryConfig `json:"inventory"`
+	FlushInterval   Duration          `json:"flush_interval"`   // Max time a result waits in the write batch
+	ShutdownTimeout Duration          `json:"shutdown_timeout"` // Grace period for in-flight polls on SIGINT/SIGTERM
+	Matchers        []MatcherConfig   `json:"matchers"`
+	Daemon          DaemonConfig      `json:"daemon"`
+	MetricsAddr     string            `json:"metrics_addr"` // Listen address for /metrics in daemon mode, e.g. ":9105"
+	Groups          []GroupConfig     `json:"groups"`
+	CredentialsFile string            `json:"credentials_file"` // Per-unit and per-group SSH credentials
+	HostKeys        HostKeyConfig     `json:"host_keys"`
+	Retry           RetryConfig       `json:"retry"`
+	Breaker         BreakerConfig     `json:"breaker"`
+	CommandTimeout  Duration          `json:"command_timeout"`  // Deadline for netstat on a unit, default 30s
+	MaxOutputBytes  int               `json:"max_output_bytes"` // Cap on captured netstat output, default 4 MiB
+	Collectors      []string          `json:"collectors"`       // Commands to try on each unit, in order: ss, netstat, netstat-names, proc
+	Names           []NameConfig      `json:"names"`            // Logical names of target IPs and ports
+	Reach           ReachConfig       `json:"reachability"`     // TCP connect probe from the collector
+	Recording       string            `json:"recording"`        // "all" rows or only "changes" with heartbeats, default all
+	Retention       RetentionConfig   `json:"retention"`
+	Spool           SpoolConfig       `json:"spool"` // Results kept on disk while the database is down
+}
+
+// DaemonConfig controls the polling schedule used with -daemon
+type DaemonConfig struct {
+	Interval         Duration `json:"interval"`          // Fixed time between full sweeps
+	Cron             string   `json:"cron"`              // Cron expression, takes precedence over Interval
+	AdaptiveInterval Duration `json:"adaptive_interval"` // Re-poll SYN_SENT/unreachable units this often, 0 disables
+}
+
+// Duration is a time.Duration written as a string such as "30s" or "5m" in JSON
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %v", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// loadConfig reads the config file at path. A missing file is not an error,
+// the collector then runs with its built-in defaults.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	err = json.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	return cfg, nil
+}
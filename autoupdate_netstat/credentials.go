@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Credential holds the SSH login for a unit, a unit group or the whole fleet
+type Credential struct {
+	Username            string   `json:"username"`
+	Password            string   `json:"password"`
+	PrivateKeys         []string `json:"private_keys"`         // Paths to PEM or OpenSSH private keys
+	Passphrase          string   `json:"passphrase"`           // Passphrase for encrypted private keys
+	Agent               *bool    `json:"agent"`                // Use the ssh-agent at $SSH_AUTH_SOCK
+	KeyboardInteractive *bool    `json:"keyboard_interactive"` // Answer keyboard-interactive prompts with Password
+
+	signers []ssh.Signer
+}
+
+// Credentials is the parsed credentials file. Each field of a unit's login
+// comes from the most specific entry that sets it: the unit's own entry, then
+// the entries of its groups in order, then the default.
+// Jump hosts have their own entries and never fall back.
+type Credentials struct {
+	Default   *Credential            `json:"default"`
+	Groups    map[string]*Credential `json:"groups"`
+	Units     map[string]*Credential `json:"units"`
+	JumpHosts map[string]*Credential `json:"jump_hosts"`
+
+	groups []*UnitGroup
+}
+
+// loadCredentials reads the credentials file at path. With an empty path the
+// legacy shared username and password are used for every unit.
+func loadCredentials(path string, groups []*UnitGroup, username, password string) (*Credentials, error) {
+	creds := &Credentials{groups: groups}
+
+	if path == "" {
+		creds.Default = &Credential{Username: username, Password: password}
+		return creds, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %v", err)
+	}
+	err = json.Unmarshal(data, creds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file: %v", err)
+	}
+
+	for name := range creds.Groups {
+		if !hasGroup(groups, name) {
+			return nil, fmt.Errorf("credentials for unknown group %q", name)
+		}
+	}
+
+	// Parse private keys once at startup so a bad key or passphrase fails fast
+	all := []*Credential{creds.Default}
+	for _, c := range creds.Groups {
+		all = append(all, c)
+	}
+	for _, c := range creds.Units {
+		all = append(all, c)
+	}
+	for _, c := range creds.JumpHosts {
+		all = append(all, c)
+	}
+	for _, c := range all {
+		if c == nil {
+			continue
+		}
+		err = c.loadKeys()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return creds, nil
+}
+
+func hasGroup(groups []*UnitGroup, name string) bool {
+	for _, g := range groups {
+		if g.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Credential) loadKeys() error {
+	for _, keyPath := range c.PrivateKeys {
+		pem, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read private key %s: %v", keyPath, err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(pem)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(c.Passphrase))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse private key %s: %v", keyPath, err)
+		}
+		c.signers = append(c.signers, signer)
+	}
+	return nil
+}
+
+// chain returns the credentials that apply to a unit, most specific first
+func (c *Credentials) chain(alias, ip string) []*Credential {
+	var chain []*Credential
+	if unit, ok := c.Units[alias]; ok {
+		chain = append(chain, unit)
+	}
+	for _, name := range groupsOf(c.groups, alias, ip) {
+		if group, ok := c.Groups[name]; ok {
+			chain = append(chain, group)
+		}
+	}
+	if c.Default != nil {
+		chain = append(chain, c.Default)
+	}
+	return chain
+}
+
+// Resolve merges the entries that apply to a unit field by field, so an
+// entry that only overrides the username keeps the keys and password of the
+// entries below it. Private keys and their passphrase come from one entry.
+func (c *Credentials) Resolve(alias, ip string) (*Credential, error) {
+	chain := c.chain(alias, ip)
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no credentials for %s (%s)", alias, ip)
+	}
+
+	var resolved Credential
+	for _, next := range chain {
+		if resolved.Username == "" {
+			resolved.Username = next.Username
+		}
+		if resolved.Password == "" {
+			resolved.Password = next.Password
+		}
+		if len(resolved.PrivateKeys) == 0 && len(next.PrivateKeys) > 0 {
+			resolved.PrivateKeys = next.PrivateKeys
+			resolved.Passphrase = next.Passphrase
+			resolved.signers = next.signers
+		}
+		if resolved.Agent == nil {
+			resolved.Agent = next.Agent
+		}
+		if resolved.KeyboardInteractive == nil {
+			resolved.KeyboardInteractive = next.KeyboardInteractive
+		}
+	}
+	if resolved.Username == "" {
+		return nil, fmt.Errorf("no username for %s (%s)", alias, ip)
+	}
+
+	return &resolved, nil
+}
+
+// AuthMethods builds the SSH auth methods for this credential, in the order
+// agent, private keys, password, keyboard-interactive. The returned close
+// function releases the agent connection and must be called after the dial.
+func (c *Credential) AuthMethods() ([]ssh.AuthMethod, func(), error) {
+	var methods []ssh.AuthMethod
+	closeFn := func() {}
+
+	if enabled(c.Agent) {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, closeFn, fmt.Errorf("agent auth requested but SSH_AUTH_SOCK is not set")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, closeFn, fmt.Errorf("failed to connect to ssh-agent: %v", err)
+		}
+		closeFn = func() { conn.Close() }
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	if len(c.signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(c.signers...))
+	}
+
+	if c.Password != "" {
+		methods = append(methods, ssh.Password(c.Password))
+	}
+
+	if enabled(c.KeyboardInteractive) {
+		password := c.Password
+		methods = append(methods, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range questions {
+				answers[i] = password
+			}
+			return answers, nil
+		}))
+	}
+
+	if len(methods) == 0 {
+		closeFn()
+		return nil, func() {}, fmt.Errorf("credential for %s has no auth method", c.Username)
+	}
+
+	return methods, closeFn, nil
+}
+
+// enabled reads an optional flag, unset means off
+func enabled(flag *bool) bool {
+	return flag != nil && *flag
+}
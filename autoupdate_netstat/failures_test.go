@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPollErrorDetail(t *testing.T) {
+	long := strings.Repeat("a", maxErrorDetail)
+
+	tests := []struct {
+		name string
+		err  string
+		want string
+	}{
+		{name: "short", err: "connection refused", want: "connection refused"},
+		{name: "exactly the limit", err: long, want: long},
+		{name: "ascii cut", err: long + "bcd", want: long},
+		{name: "no cut inside a character", err: long[:maxErrorDetail-1] + "é", want: long[:maxErrorDetail-1]},
+		{name: "character ending at the limit", err: long[:maxErrorDetail-2] + "é!", want: long[:maxErrorDetail-2] + "é"},
+		{name: "no cut inside a 4-byte character", err: long[:maxErrorDetail-2] + "😀", want: long[:maxErrorDetail-2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newPollError(ClassCommandFailed, errors.New(tt.err)).Detail()
+			if got != tt.want {
+				t.Errorf("Detail() = %d bytes ending %q, want %d bytes ending %q", len(got), tail(got), len(tt.want), tail(tt.want))
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("Detail() is not valid UTF-8")
+			}
+		})
+	}
+}
+
+func tail(s string) string {
+	if len(s) > 8 {
+		return s[len(s)-8:]
+	}
+	return s
+}
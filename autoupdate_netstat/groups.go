@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path"
+)
+
+// GroupConfig assigns units to a named group by alias pattern or address range
+type GroupConfig struct {
+	Name      string           `json:"name"`
+	Aliases   []string         `json:"aliases"`    // Glob patterns such as "DT1*"
+	CIDRs     []string         `json:"cidrs"`      // Networks such as "10.1.0.0/16"
+	JumpHosts []JumpHostConfig `json:"jump_hosts"` // Gateways to reach these units through, in order
+}
+
+// UnitGroup is a parsed GroupConfig
+type UnitGroup struct {
+	Name     string
+	aliases  []string
+	networks []*net.IPNet
+}
+
+func newUnitGroups(configs []GroupConfig) ([]*UnitGroup, error) {
+	groups := make([]*UnitGroup, 0, len(configs))
+	for _, cfg := range configs {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("group has no name")
+		}
+
+		g := &UnitGroup{Name: cfg.Name}
+		for _, pattern := range cfg.Aliases {
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return nil, fmt.Errorf("group %s: invalid alias pattern %q: %v", cfg.Name, pattern, err)
+			}
+			g.aliases = append(g.aliases, pattern)
+		}
+		for _, cidr := range cfg.CIDRs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("group %s: invalid CIDR %q: %v", cfg.Name, cidr, err)
+			}
+			g.networks = append(g.networks, network)
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// Contains reports whether the unit with the given alias and IP belongs to the group
+func (g *UnitGroup) Contains(alias, ip string) bool {
+	for _, pattern := range g.aliases {
+		if ok, _ := path.Match(pattern, alias); ok {
+			return true
+		}
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, network := range g.networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupsOf returns the names of every group the unit belongs to, in config order
+func groupsOf(groups []*UnitGroup, alias, ip string) []string {
+	var names []string
+	for _, g := range groups {
+		if g.Contains(alias, ip) {
+			names = append(names, g.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InventorySource yields the units to poll
+type InventorySource interface {
+	Name() string
+	Servers(ctx context.Context) ([]Server, error)
+}
+
+// InventoryConfig describes one inventory source. Sources are listed in
+// precedence order: when two sources know the same alias, the first wins.
+type InventoryConfig struct {
+	Type      string      `json:"type"`       // http, file, sql or static
+	URL       string      `json:"url"`        // http: endpoint returning the /ipunit JSON
+	Path      string      `json:"path"`       // file: .csv, .yaml/.yml or .json file
+	Driver    string      `json:"driver"`     // sql: mysql, postgres or sqlite
+	DSN       string      `json:"dsn"`        // sql: connection string
+	Query     string      `json:"query"`      // sql: must return alias and IP columns
+	Units     []UnitEntry `json:"units"`      // static: the units themselves
+	CacheFile string      `json:"cache_file"` // Last-known copy used when the source is down, http default inventory_cache.json
+}
+
+// defaultInventoryCache is where http sources keep their last-known copy
+// when no cache_file is configured
+const defaultInventoryCache = "inventory_cache.json"
+
+// UnitEntry is a unit as written in inventory files and static lists
+type UnitEntry struct {
+	ID string `json:"id" yaml:"id"`
+	IP string `json:"ip" yaml:"ip"`
+}
+
+func (u UnitEntry) server() Server {
+	ip := strings.TrimSpace(u.IP)
+	return Server{
+		IP:    IPField{String: ip, Valid: net.ParseIP(ip) != nil},
+		Alias: strings.TrimSpace(u.ID),
+	}
+}
+
+// Inventory merges several sources into one validated server list
+type Inventory struct {
+	sources []InventorySource
+}
+
+func newInventory(configs []InventoryConfig, defaultURL string) (*Inventory, error) {
+	if len(configs) == 0 {
+		configs = []InventoryConfig{{Type: "http", URL: defaultURL}}
+	}
+
+	inv := &Inventory{}
+	for i, cfg := range configs {
+		var src InventorySource
+		name := fmt.Sprintf("%s#%d", cfg.Type, i+1)
+		switch cfg.Type {
+		case "http":
+			src = &httpSource{name: name, url: cfg.URL}
+			if cfg.CacheFile == "" {
+				cfg.CacheFile = defaultInventoryCache
+				if i > 0 {
+					cfg.CacheFile = fmt.Sprintf("inventory_cache_%d.json", i+1)
+				}
+			}
+		case "file":
+			src = &fileSource{name: name, path: cfg.Path}
+		case "sql":
+			query := cfg.Query
+			if query == "" {
+				query = "SELECT id_unit, ip_unit FROM units"
+			}
+			src = &sqlSource{name: name, driver: cfg.Driver, dsn: cfg.DSN, query: query}
+		case "static":
+			src = &staticSource{name: name, units: cfg.Units}
+		default:
+			return nil, fmt.Errorf("inventory source %d: unknown type %q", i+1, cfg.Type)
+		}
+
+		if cfg.CacheFile != "" {
+			src = &cachedSource{InventorySource: src, path: cfg.CacheFile}
+		}
+		inv.sources = append(inv.sources, src)
+	}
+	return inv, nil
+}
+
+// Servers fetches every source and merges the results. A failing source is
+// skipped; the merge only fails when no source returned anything.
+func (inv *Inventory) Servers(ctx context.Context) ([]Server, error) {
+	var merged []Server
+	aliases := make(map[string]string) // alias -> source that defined it
+	ips := make(map[string]string)     // Parsed IP -> alias that owns it
+	failed := 0
+
+	for _, src := range inv.sources {
+		servers, err := src.Servers(ctx)
+		if err != nil {
+			log.Printf("Inventory source %s failed: %v", src.Name(), err)
+			failed++
+			continue
+		}
+
+		for _, server := range servers {
+			if server.Alias == "" {
+				log.Printf("Inventory source %s: skipping unit without alias (%s)", src.Name(), server.IP.String)
+				continue
+			}
+			if owner, ok := aliases[server.Alias]; ok {
+				if owner == src.Name() {
+					log.Printf("Inventory source %s: duplicate alias %s, keeping the first entry", src.Name(), server.Alias)
+				}
+				continue // Lower precedence source, already defined
+			}
+			if ip := net.ParseIP(strings.TrimSpace(server.IP.String)); server.IP.Valid && ip != nil {
+				// Keyed on the parsed address so spellings of one IP collide
+				if other, ok := ips[ip.String()]; ok {
+					log.Printf("Inventory source %s: IP %s of %s is already used by %s, skipping", src.Name(), server.IP.String, server.Alias, other)
+					continue
+				}
+				ips[ip.String()] = server.Alias
+			}
+
+			aliases[server.Alias] = src.Name()
+			merged = append(merged, server)
+		}
+	}
+
+	if failed == len(inv.sources) {
+		return nil, fmt.Errorf("all %d inventory sources failed", failed)
+	}
+	return merged, nil
+}
+
+// httpSource is the central /ipunit endpoint
+type httpSource struct {
+	name string
+	url  string
+}
+
+func (s *httpSource) Name() string { return s.name }
+
+func (s *httpSource) Servers(ctx context.Context) ([]Server, error) {
+	return fetchServerList(ctx, s.url)
+}
+
+// fileSource reads units from a CSV, YAML or JSON file
+type fileSource struct {
+	name string
+	path string
+}
+
+func (s *fileSource) Name() string { return s.name }
+
+func (s *fileSource) Servers(ctx context.Context) ([]Server, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory file: %v", err)
+	}
+
+	var units []UnitEntry
+	switch strings.ToLower(filepath.Ext(s.path)) {
+	case ".csv":
+		units, err = parseInventoryCSV(data)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &units)
+	case ".json":
+		err = json.Unmarshal(data, &units)
+	default:
+		err = fmt.Errorf("unsupported inventory file type %q", filepath.Ext(s.path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse inventory file %s: %v", s.path, err)
+	}
+
+	servers := make([]Server, 0, len(units))
+	for _, u := range units {
+		servers = append(servers, u.server())
+	}
+	return servers, nil
+}
+
+// parseInventoryCSV reads "id,ip" rows. A first row of "id,ip" is treated as a header.
+func parseInventoryCSV(data []byte) ([]UnitEntry, error) {
+	r := csv.NewReader(strings.NewReader(string(data)))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var units []UnitEntry
+	for i, rec := range records {
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("line %d: expected id,ip", i+1)
+		}
+		if i == 0 && strings.EqualFold(rec[0], "id") && strings.EqualFold(rec[1], "ip") {
+			continue
+		}
+		units = append(units, UnitEntry{ID: rec[0], IP: rec[1]})
+	}
+	return units, nil
+}
+
+// sqlSource reads units from a table through any of the store drivers
+type sqlSource struct {
+	name   string
+	driver string
+	dsn    string
+	query  string
+}
+
+func (s *sqlSource) Name() string { return s.name }
+
+func (s *sqlSource) Servers(ctx context.Context) ([]Server, error) {
+	db, err := sql.Open(s.driver, s.dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, s.query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var servers []Server
+	for rows.Next() {
+		var alias string
+		var ip sql.NullString
+		err := rows.Scan(&alias, &ip)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, UnitEntry{ID: alias, IP: ip.String}.server())
+	}
+	return servers, rows.Err()
+}
+
+// staticSource is a fixed list of units from the config file
+type staticSource struct {
+	name  string
+	units []UnitEntry
+}
+
+func (s *staticSource) Name() string { return s.name }
+
+func (s *staticSource) Servers(ctx context.Context) ([]Server, error) {
+	servers := make([]Server, 0, len(s.units))
+	for _, u := range s.units {
+		servers = append(servers, u.server())
+	}
+	return servers, nil
+}
+
+// cachedSource saves every successful fetch and serves the last-known copy
+// when the wrapped source fails. An empty list counts as a failure, so a
+// broken endpoint answering [] does not wipe the cache.
+type cachedSource struct {
+	InventorySource
+	path string
+}
+
+func (s *cachedSource) Servers(ctx context.Context) ([]Server, error) {
+	servers, err := s.InventorySource.Servers(ctx)
+	if err == nil && len(servers) > 0 {
+		s.save(servers)
+		return servers, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("source returned no units")
+	}
+
+	data, readErr := ioutil.ReadFile(s.path)
+	if readErr != nil {
+		return nil, err
+	}
+	var cached []Server
+	if json.Unmarshal(data, &cached) != nil || len(cached) == 0 {
+		return nil, err
+	}
+
+	log.Printf("Inventory source %s failed (%v), using last-known copy from %s", s.Name(), err, s.path)
+	return cached, nil
+}
+
+func (s *cachedSource) save(servers []Server) {
+	data, err := json.Marshal(servers)
+	if err != nil {
+		return
+	}
+
+	// Write to a temp file first so a crash never leaves a truncated cache
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		log.Printf("Failed to save inventory cache %s: %v", s.path, err)
+	}
+}
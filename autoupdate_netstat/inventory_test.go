@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// stubSource is an inventory source returning fixed servers or an error
+type stubSource struct {
+	name    string
+	servers []Server
+	err     error
+}
+
+func (s *stubSource) Name() string { return s.name }
+
+func (s *stubSource) Servers(ctx context.Context) ([]Server, error) {
+	return s.servers, s.err
+}
+
+func unit(alias, ip string) Server {
+	return UnitEntry{ID: alias, IP: ip}.server()
+}
+
+func TestInventoryMerge(t *testing.T) {
+	down := errors.New("source is down")
+
+	tests := []struct {
+		name    string
+		sources []InventorySource
+		want    []Server
+		wantErr bool
+	}{
+		{
+			name: "first source wins an alias",
+			sources: []InventorySource{
+				&stubSource{name: "a", servers: []Server{unit("U1", "10.0.0.1")}},
+				&stubSource{name: "b", servers: []Server{unit("U1", "10.0.0.9"), unit("U2", "10.0.0.2")}},
+			},
+			want: []Server{unit("U1", "10.0.0.1"), unit("U2", "10.0.0.2")},
+		},
+		{
+			name: "duplicate alias in one source keeps the first entry",
+			sources: []InventorySource{
+				&stubSource{name: "a", servers: []Server{unit("U1", "10.0.0.1"), unit("U1", "10.0.0.2")}},
+			},
+			want: []Server{unit("U1", "10.0.0.1")},
+		},
+		{
+			name: "an IP belongs to one alias",
+			sources: []InventorySource{
+				&stubSource{name: "a", servers: []Server{unit("U1", "10.0.0.1")}},
+				&stubSource{name: "b", servers: []Server{unit("U2", "10.0.0.1")}},
+			},
+			want: []Server{unit("U1", "10.0.0.1")},
+		},
+		{
+			name: "an IP belongs to one alias whatever its spelling",
+			sources: []InventorySource{
+				&stubSource{name: "a", servers: []Server{unit("U1", "10.0.0.1"), unit("U2", "::ffff:10.0.0.1")}},
+				&stubSource{name: "b", servers: []Server{unit("U3", "2001:db8::1"), unit("U4", "2001:DB8:0::1")}},
+			},
+			want: []Server{unit("U1", "10.0.0.1"), unit("U3", "2001:db8::1")},
+		},
+		{
+			name: "invalid IPs do not claim an address",
+			sources: []InventorySource{
+				&stubSource{name: "a", servers: []Server{unit("U1", "unit-1"), unit("U2", "unit-1")}},
+			},
+			want: []Server{unit("U1", "unit-1"), unit("U2", "unit-1")},
+		},
+		{
+			name: "units without alias are skipped, units without IP kept",
+			sources: []InventorySource{
+				&stubSource{name: "a", servers: []Server{unit("", "10.0.0.1"), unit("U2", "")}},
+			},
+			want: []Server{unit("U2", "")},
+		},
+		{
+			name: "failing source is skipped",
+			sources: []InventorySource{
+				&stubSource{name: "a", err: down},
+				&stubSource{name: "b", servers: []Server{unit("U1", "10.0.0.1")}},
+			},
+			want: []Server{unit("U1", "10.0.0.1")},
+		},
+		{
+			name: "all sources failed",
+			sources: []InventorySource{
+				&stubSource{name: "a", err: down},
+				&stubSource{name: "b", err: down},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv := &Inventory{sources: tt.sources}
+			got, err := inv.Servers(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Servers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Servers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnitEntryServer(t *testing.T) {
+	tests := []struct {
+		ip        string
+		wantIP    string
+		wantValid bool
+	}{
+		{ip: "10.0.0.1", wantIP: "10.0.0.1", wantValid: true},
+		{ip: " 10.0.0.1 ", wantIP: "10.0.0.1", wantValid: true},
+		{ip: "2001:db8::1", wantIP: "2001:db8::1", wantValid: true},
+		{ip: "", wantIP: "", wantValid: false},
+		{ip: "10.0.0.256", wantIP: "10.0.0.256", wantValid: false},
+		{ip: "10.0.0.01", wantIP: "10.0.0.01", wantValid: false},
+		{ip: "unit-1.local", wantIP: "unit-1.local", wantValid: false},
+	}
+
+	for _, tt := range tests {
+		got := UnitEntry{ID: "U1", IP: tt.ip}.server().IP
+		if got.String != tt.wantIP || got.Valid != tt.wantValid {
+			t.Errorf("server() IP of %q = %+v, want %q valid %v", tt.ip, got, tt.wantIP, tt.wantValid)
+		}
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	want := []Server{unit("U1", "10.0.0.1"), unit("U2", "10.0.0.2")}
+
+	tests := []struct {
+		file    string
+		content string
+		wantErr bool
+	}{
+		{file: "units.csv", content: "id,ip\n# spare units\nU1,10.0.0.1\nU2, 10.0.0.2\n"},
+		{file: "units.yaml", content: "- id: U1\n  ip: 10.0.0.1\n- id: U2\n  ip: 10.0.0.2\n"},
+		{file: "units.json", content: `[{"id": "U1", "ip": "10.0.0.1"}, {"id": " U2 ", "ip": "10.0.0.2"}]`},
+		{file: "units.csv", content: "U1\n", wantErr: true},
+		{file: "units.txt", content: "U1 10.0.0.1\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			err := ioutil.WriteFile(path, []byte(tt.content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := (&fileSource{name: "file", path: path}).Servers(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Servers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, want) {
+				t.Errorf("Servers() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestCachedSource(t *testing.T) {
+	servers := []Server{unit("U1", "10.0.0.1")}
+	down := errors.New("source is down")
+
+	tests := []struct {
+		name    string
+		fetches []*stubSource // What the wrapped source returns, one per call
+		want    []Server      // Result of the last call
+		wantErr bool
+	}{
+		{
+			name:    "success is served",
+			fetches: []*stubSource{{servers: servers}},
+			want:    servers,
+		},
+		{
+			name:    "failure serves the last success",
+			fetches: []*stubSource{{servers: servers}, {err: down}},
+			want:    servers,
+		},
+		{
+			name:    "failure without a cache",
+			fetches: []*stubSource{{err: down}},
+			wantErr: true,
+		},
+		{
+			name:    "empty list keeps the cache",
+			fetches: []*stubSource{{servers: servers}, {servers: []Server{}}, {err: down}},
+			want:    servers,
+		},
+		{
+			name:    "empty list without a cache",
+			fetches: []*stubSource{{servers: []Server{}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubSource{name: "stub"}
+			src := &cachedSource{InventorySource: stub, path: filepath.Join(t.TempDir(), "cache.json")}
+
+			var got []Server
+			var err error
+			for _, fetch := range tt.fetches {
+				stub.servers, stub.err = fetch.servers, fetch.err
+				got, err = src.Servers(context.Background())
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Servers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Servers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    []Server
+		wantErr bool
+	}{
+		{
+			name:   "list",
+			status: http.StatusOK,
+			body:   `[{"id": "U1", "ip": {"String": "10.0.0.1", "Valid": true}}]`,
+			want:   []Server{unit("U1", "10.0.0.1")},
+		},
+		{name: "server error", status: http.StatusInternalServerError, body: `[]`, wantErr: true},
+		{name: "not found", status: http.StatusNotFound, body: `not found`, wantErr: true},
+		{name: "bad JSON", status: http.StatusOK, body: `<html>`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			got, err := (&httpSource{name: "http", url: srv.URL}).Servers(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Servers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Servers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewInventoryCacheFile(t *testing.T) {
+	configs := []InventoryConfig{
+		{Type: "http", URL: "http://a/ipunit"},
+		{Type: "http", URL: "http://b/ipunit"},
+		{Type: "http", URL: "http://c/ipunit", CacheFile: "/var/cache/c.json"},
+		{Type: "file", Path: "units.csv"},
+	}
+	want := []string{defaultInventoryCache, "inventory_cache_2.json", "/var/cache/c.json", ""}
+
+	inv, err := newInventory(configs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, src := range inv.sources {
+		path := ""
+		if cached, ok := src.(*cachedSource); ok {
+			path = cached.path
+		}
+		if path != want[i] {
+			t.Errorf("source %d: cache file = %q, want %q", i+1, path, want[i])
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// JumpHostConfig is one SSH hop between the collector and the units of a group.
+// Its login comes from the "jump_hosts" section of the credentials file.
+type JumpHostConfig struct {
+	Name     string        `json:"name"`
+	Address  string        `json:"address"` // host:port of the gateway
+	HostKeys HostKeyConfig `json:"host_keys"`
+}
+
+// JumpHost is a gateway ready to be dialled
+type JumpHost struct {
+	Name       string
+	Address    string
+	credential *Credential
+	hostKeys   *HostKeyStore
+}
+
+// errHandshakeTimeout is returned when an SSH handshake through a tunnel stalls
+var errHandshakeTimeout = errors.New("ssh handshake timed out")
+
+// newJumpHosts builds the hop chain of every group that has one
+func newJumpHosts(configs []GroupConfig, creds *Credentials) (map[string][]*JumpHost, error) {
+	chains := make(map[string][]*JumpHost)
+	for _, group := range configs {
+		for _, cfg := range group.JumpHosts {
+			if cfg.Name == "" || cfg.Address == "" {
+				return nil, fmt.Errorf("group %s: jump host needs a name and an address", group.Name)
+			}
+
+			credential, ok := creds.JumpHosts[cfg.Name]
+			if !ok || credential.Username == "" {
+				return nil, fmt.Errorf("group %s: no credentials for jump host %s", group.Name, cfg.Name)
+			}
+			hostKeys, err := newHostKeyStore(cfg.HostKeys)
+			if err != nil {
+				return nil, fmt.Errorf("jump host %s: %v", cfg.Name, err)
+			}
+
+			chains[group.Name] = append(chains[group.Name], &JumpHost{
+				Name:       cfg.Name,
+				Address:    cfg.Address,
+				credential: credential,
+				hostKeys:   hostKeys,
+			})
+		}
+	}
+	return chains, nil
+}
+
+// jumpChain returns the hops for a unit, taken from the first of its groups that has any
+func (c *Collector) jumpChain(server Server) []*JumpHost {
+	for _, name := range groupsOf(c.groups, server.Alias, server.IP.String) {
+		if chain, ok := c.jumpHosts[name]; ok {
+			return chain
+		}
+	}
+	return nil
+}
+
+// dialUnit connects to a unit, through its jump hosts if it has any. The
+// returned function closes the unit connection and every hop. Failures of a
+// hop are classed as gateway failures so they are not blamed on the unit.
+func (c *Collector) dialUnit(ctx context.Context, server Server, config *ssh.ClientConfig) (*ssh.Client, func(), *PollError) {
+	address := net.JoinHostPort(server.IP.String, "22")
+	hops := c.jumpChain(server)
+	if len(hops) == 0 {
+		client, err := dialSSH(ctx, address, config)
+		if err != nil {
+			return nil, nil, classifyDialError(err)
+		}
+		return client, func() { client.Close() }, nil
+	}
+
+	var opened []*ssh.Client
+	closeAll := func() {
+		for i := len(opened) - 1; i >= 0; i-- {
+			opened[i].Close()
+		}
+	}
+
+	var prev *ssh.Client
+	for _, hop := range hops {
+		client, err := hop.dial(ctx, prev)
+		if err != nil {
+			closeAll()
+			return nil, nil, newPollError(ClassGatewayFailure, fmt.Errorf("jump host %s (%s): %v", hop.Name, hop.Address, err))
+		}
+		opened = append(opened, client)
+		prev = client
+	}
+
+	// The last hop could not reach the unit: a unit failure, not a gateway one
+	conn, err := prev.DialContext(ctx, "tcp", address)
+	if err != nil {
+		closeAll()
+		return nil, nil, classifyDialError(err)
+	}
+	client, err := clientOverConn(ctx, conn, address, config)
+	if err != nil {
+		closeAll()
+		return nil, nil, classifyDialError(err)
+	}
+	opened = append(opened, client)
+
+	return client, closeAll, nil
+}
+
+// dial connects to this hop, directly or through the previous one
+func (j *JumpHost) dial(ctx context.Context, prev *ssh.Client) (*ssh.Client, error) {
+	auth, closeAuth, err := j.credential.AuthMethods()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAuth()
+
+	config := &ssh.ClientConfig{
+		User:            j.credential.Username,
+		Auth:            auth,
+		HostKeyCallback: j.hostKeys.Callback(),
+		Timeout:         sshTimeout,
+	}
+
+	if prev == nil {
+		return dialSSH(ctx, j.Address, config)
+	}
+
+	conn, err := prev.DialContext(ctx, "tcp", j.Address)
+	if err != nil {
+		return nil, err
+	}
+	return clientOverConn(ctx, conn, j.Address, config)
+}
+
+// dialSSH is ssh.Dial with a context: the TCP connect and the handshake both
+// stop when ctx ends
+func dialSSH(ctx context.Context, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return clientOverConn(ctx, conn, address, config)
+}
+
+// clientOverConn runs the SSH handshake over conn. Tunnelled connections do not
+// support deadlines, so the timeout or the end of ctx closes the connection.
+func clientOverConn(ctx context.Context, conn net.Conn, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	type handshake struct {
+		client *ssh.Client
+		err    error
+	}
+	done := make(chan handshake, 1)
+
+	go func() {
+		c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+		if err != nil {
+			done <- handshake{err: err}
+			return
+		}
+		done <- handshake{client: ssh.NewClient(c, chans, reqs)}
+	}()
+
+	select {
+	case h := <-done:
+		if h.err != nil {
+			conn.Close()
+		}
+		return h.client, h.err
+	case <-time.After(sshTimeout):
+		conn.Close()
+		return nil, errHandshakeTimeout
+	case <-ctx.Done():
+		conn.Close()
+		return nil, ctx.Err()
+	}
+}
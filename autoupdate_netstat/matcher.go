@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// MatcherConfig describes one link target to look for in netstat output
+type MatcherConfig struct {
+	Name  string `json:"name"`  // Name stored with the result, e.g. "master" or "gps"
+	Type  string `json:"type"`  // One of hostname, ip, cidr, port or regex
+	Value string `json:"value"` // Hostname, IP, CIDR, port or pattern to match
+}
+
+// Matcher decides whether a connection belongs to a named target
+type Matcher struct {
+	Name  string
+	match func(conn Connection) bool
+}
+
+// TargetResult is the link state found for a single matcher in one poll
+type TargetResult struct {
+	Target         string `json:"target"`
+	ForeignAddress string `json:"foreign_address"`
+	ForeignName    string `json:"foreign_name"`
+	Status         string `json:"status"`
+	// States counts the target's connections in each TCP state, so a pile
+	// of CLOSE_WAIT next to a working link still shows
+	States map[string]int `json:"states,omitempty"`
+}
+
+// defaultMatchers keeps the historical behaviour of looking for the "master"
+// host, which needs a names entry for master or the netstat-names collector
+var defaultMatchers = []MatcherConfig{
+	{Name: "master", Type: "hostname", Value: "master"},
+}
+
+func newMatcher(cfg MatcherConfig) (*Matcher, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("matcher has no name")
+	}
+
+	m := &Matcher{Name: cfg.Name}
+	switch cfg.Type {
+	case "hostname":
+		host := strings.ToLower(cfg.Value)
+		// Matches the logical name given by the names config, or a name
+		// already resolved on the unit
+		m.match = func(conn Connection) bool {
+			if strings.EqualFold(conn.ForeignName, host) {
+				return true
+			}
+			foreign := strings.ToLower(conn.ForeignAddress)
+			return foreign == host || strings.HasPrefix(foreign, host+".")
+		}
+	case "ip":
+		ip := net.ParseIP(cfg.Value)
+		if ip == nil {
+			return nil, fmt.Errorf("matcher %s: invalid IP %q", cfg.Name, cfg.Value)
+		}
+		m.match = func(conn Connection) bool {
+			foreign := net.ParseIP(conn.ForeignAddress)
+			return foreign != nil && foreign.Equal(ip)
+		}
+	case "cidr":
+		_, network, err := net.ParseCIDR(cfg.Value)
+		if err != nil {
+			return nil, fmt.Errorf("matcher %s: invalid CIDR %q: %v", cfg.Name, cfg.Value, err)
+		}
+		m.match = func(conn Connection) bool {
+			foreign := net.ParseIP(conn.ForeignAddress)
+			return foreign != nil && network.Contains(foreign)
+		}
+	case "port":
+		if cfg.Value == "" {
+			return nil, fmt.Errorf("matcher %s: empty port", cfg.Name)
+		}
+		m.match = func(conn Connection) bool {
+			return conn.ForeignPort == cfg.Value
+		}
+	case "regex":
+		re, err := regexp.Compile(cfg.Value)
+		if err != nil {
+			return nil, fmt.Errorf("matcher %s: invalid regex %q: %v", cfg.Name, cfg.Value, err)
+		}
+		m.match = func(conn Connection) bool {
+			return re.MatchString(conn.Foreign())
+		}
+	default:
+		return nil, fmt.Errorf("matcher %s: unknown type %q", cfg.Name, cfg.Type)
+	}
+
+	return m, nil
+}
+
+// newMatchers builds the matcher set, falling back to defaultMatchers when none are configured
+func newMatchers(configs []MatcherConfig) ([]*Matcher, error) {
+	if len(configs) == 0 {
+		configs = defaultMatchers
+	}
+
+	seen := make(map[string]bool)
+	matchers := make([]*Matcher, 0, len(configs))
+	for _, cfg := range configs {
+		if seen[cfg.Name] {
+			return nil, fmt.Errorf("duplicate matcher name %q", cfg.Name)
+		}
+		seen[cfg.Name] = true
+
+		m, err := newMatcher(cfg)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+
+	return matchers, nil
+}
+
+// Match reports whether the connection's foreign endpoint belongs to this target
+func (m *Matcher) Match(conn Connection) bool {
+	return m.match(conn)
+}
+
+// matchTargets returns one result per matcher, in matcher order. When several
+// connections match, the one in the strongest state (see tcpStatePrecedence)
+// decides the status and foreign address, the first of them on a tie.
+func matchTargets(matchers []*Matcher, connections []Connection) []TargetResult {
+	results := make([]TargetResult, 0, len(matchers))
+	for _, m := range matchers {
+		result := TargetResult{Target: m.Name}
+		best := len(tcpStatePrecedence)
+		matched := false
+		for _, conn := range connections {
+			if !m.Match(conn) {
+				continue
+			}
+			// An untracked state only names the endpoint if nothing better turns up
+			if !matched {
+				result.ForeignAddress = conn.Foreign()
+				result.ForeignName = conn.ForeignName
+				matched = true
+			}
+			rank, ok := tcpStateRank(conn.State)
+			if !ok {
+				continue
+			}
+			if result.States == nil {
+				result.States = make(map[string]int)
+			}
+			result.States[conn.State]++
+			if rank < best {
+				best = rank
+				result.ForeignAddress = conn.Foreign()
+				result.ForeignName = conn.ForeignName
+				result.Status = conn.State
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// migrationFiles holds the schema of every backend as numbered SQL files,
+// migrations/<driver>/NNNN_name.up.sql and an optional NNNN_name.down.sql.
+// The mysql collector embeds a copy of the mysql set: both record their
+// migrations in the same schema_migrations, so the sets must stay identical.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // Empty when the migration cannot be reverted
+}
+
+// MigrationStatus is a migration and whether the database has it
+type MigrationStatus struct {
+	Migration
+	AppliedAt int64 // Unix seconds, 0 when pending
+}
+
+// loadMigrations reads the migrations of a driver, in version order
+func loadMigrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %s: %v", driver, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var base string
+		up := strings.HasSuffix(name, ".up.sql")
+		switch {
+		case up:
+			base = strings.TrimSuffix(name, ".up.sql")
+		case strings.HasSuffix(name, ".down.sql"):
+			base = strings.TrimSuffix(name, ".down.sql")
+		default:
+			continue
+		}
+
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("bad migration file name %s", name)
+		}
+		data, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if up {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements cuts a migration file into statements at semicolons that
+// end a line. Comment lines are dropped.
+func splitStatements(sql string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// runMigrate is the "migrate up|down|status" command. It returns the exit code.
+func runMigrate(store Store, command string) int {
+	var err error
+	switch command {
+	case "up":
+		err = store.Init()
+	case "down":
+		err = store.MigrateDown()
+	case "status":
+		var statuses []MigrationStatus
+		statuses, err = store.Migrations()
+		if err == nil {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+			for _, st := range statuses {
+				applied := "pending"
+				if st.AppliedAt > 0 {
+					applied = time.Unix(st.AppliedAt, 0).Format(time.RFC3339)
+				}
+				fmt.Fprintf(tw, "%04d\t%s\t%s\n", st.Version, st.Name, applied)
+			}
+			err = tw.Flush()
+		}
+	default:
+		log.Printf("Unknown migrate command %q, use up, down or status", command)
+		return 2
+	}
+
+	if err != nil {
+		log.Printf("migrate %s failed: %v", command, err)
+		return 1
+	}
+	return 0
+}
+
+// applied returns the applied migrations by version with their time
+func (s *sqlStore) applied(ctx context.Context) (map[int]int64, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]int64)
+	for rows.Next() {
+		var version int
+		var at int64
+		err := rows.Scan(&version, &at)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// Init brings the schema up to date by applying every pending migration. A
+// database from before versioned migrations is adopted: the baseline only
+// creates what is missing, then the columns older collectors lacked are added.
+func (s *sqlStore) Init() error {
+	ctx := context.Background()
+	migrations, err := loadMigrations(s.dialect.driver)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INT PRIMARY KEY,
+        name VARCHAR(255),
+        applied_at BIGINT
+    )`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	applied, err := s.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		log.Printf("Applying migration %04d %s", m.Version, m.Name)
+		err := s.runMigration(ctx, m.Up, func(tx execer) error {
+			_, err := tx.ExecContext(ctx, s.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"), m.Version, m.Name, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d %s failed: %v", m.Version, m.Name, err)
+		}
+		if m.Version == 1 {
+			err = s.addLegacyColumns()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addLegacyColumns adds the columns that collectors from before versioned
+// migrations added one by one, to tables the baseline found already there
+func (s *sqlStore) addLegacyColumns() error {
+	for _, col := range s.dialect.columns {
+		rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", col.name, col.table))
+		if err == nil {
+			rows.Close()
+			continue
+		}
+		_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.name, col.definition))
+		if err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %v", col.table, col.name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the latest applied migration
+func (s *sqlStore) MigrateDown() error {
+	ctx := context.Background()
+	migrations, err := loadMigrations(s.dialect.driver)
+	if err != nil {
+		return err
+	}
+	applied, err := s.applied(ctx)
+	if err != nil {
+		return fmt.Errorf("no migrations applied: %v", err)
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return fmt.Errorf("migration %04d %s cannot be reverted", m.Version, m.Name)
+		}
+		log.Printf("Reverting migration %04d %s", m.Version, m.Name)
+		return s.runMigration(ctx, m.Down, func(tx execer) error {
+			_, err := tx.ExecContext(ctx, s.rebind("DELETE FROM schema_migrations WHERE version = ?"), m.Version)
+			return err
+		})
+	}
+	return fmt.Errorf("no migrations applied")
+}
+
+// Migrations lists every known migration with when it was applied
+func (s *sqlStore) Migrations() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(s.dialect.driver)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.applied(context.Background())
+	if err != nil {
+		// Nothing was ever migrated, everything is pending
+		applied = nil
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		statuses = append(statuses, MigrationStatus{Migration: m, AppliedAt: applied[m.Version]})
+	}
+	return statuses, nil
+}
+
+// execer is the part of *sql.Tx a migration step needs
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// runMigration runs the statements of a migration file and then record, in
+// one transaction. MySQL commits each DDL statement on its own, so there a
+// failed migration may be left half done and must be fixed by hand.
+func (s *sqlStore) runMigration(ctx context.Context, sqlText string, record func(tx execer) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(sqlText) {
+		_, err := tx.ExecContext(ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+	err = record(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{
+			name: "one statement per line",
+			sql:  "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			want: []string{"CREATE TABLE a (id INT);", "CREATE TABLE b (id INT);"},
+		},
+		{
+			name: "statement over several lines",
+			sql:  "CREATE TABLE a (\n    id INT,\n    name TEXT\n);\n",
+			want: []string{"CREATE TABLE a (\n    id INT,\n    name TEXT\n);"},
+		},
+		{
+			name: "comments and blank lines are dropped",
+			sql:  "-- Baseline\n\nCREATE TABLE a (id INT);\n  -- indented comment\n\nDROP TABLE b;\n",
+			want: []string{"CREATE TABLE a (id INT);", "DROP TABLE b;"},
+		},
+		{
+			name: "semicolon inside a line does not split",
+			sql:  "INSERT INTO a (name) VALUES ('x;y');\n",
+			want: []string{"INSERT INTO a (name) VALUES ('x;y');"},
+		},
+		{
+			name: "last statement without semicolon",
+			sql:  "CREATE TABLE a (id INT);\nDROP TABLE b",
+			want: []string{"CREATE TABLE a (id INT);", "DROP TABLE b"},
+		},
+		{
+			name: "only comments",
+			sql:  "-- nothing to do\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitStatements(tt.sql)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	var names []string
+	for _, driver := range []string{"mysql", "postgres", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			migrations, err := loadMigrations(driver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for i, m := range migrations {
+				if m.Version != i+1 {
+					t.Errorf("migration %d has version %d, versions must have no gaps", i+1, m.Version)
+				}
+				if m.Version > 1 && m.Down == "" {
+					t.Errorf("migration %04d_%s has no down file", m.Version, m.Name)
+				}
+				got = append(got, m.Name)
+			}
+			// Every backend goes through the same schema versions
+			if names == nil {
+				names = got
+			} else if !reflect.DeepEqual(got, names) {
+				t.Errorf("migrations = %v, want %v as for mysql", got, names)
+			}
+		})
+	}
+}
+
+func TestSQLiteMigrateUpDown(t *testing.T) {
+	store, err := newSQLiteStore(filepath.Join(t.TempDir(), "netstat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	applied := func() int {
+		t.Helper()
+		statuses, err := store.Migrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, st := range statuses {
+			if st.AppliedAt > 0 {
+				n++
+			}
+		}
+		return n
+	}
+
+	migrations, err := loadMigrations("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Init()
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if n := applied(); n != len(migrations) {
+		t.Fatalf("%d migrations applied after up, want %d", n, len(migrations))
+	}
+	err = store.MigrateDown()
+	if err != nil {
+		t.Fatalf("MigrateDown() error = %v", err)
+	}
+	if n := applied(); n != len(migrations)-1 {
+		t.Fatalf("%d migrations applied after down, want %d", n, len(migrations)-1)
+	}
+	// Up again after down leaves the schema where it was
+	err = store.Init()
+	if err != nil {
+		t.Fatalf("Init() after down error = %v", err)
+	}
+	if n := applied(); n != len(migrations) {
+		t.Fatalf("%d migrations applied after up again, want %d", n, len(migrations))
+	}
+}
@@ -0,0 +1,90 @@
+-- Schema as it stood before versioned migrations. Every statement is
+-- IF NOT EXISTS so databases created by older collectors are adopted as is.
+
+CREATE TABLE IF NOT EXISTS display_status (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    date_time DATETIME DEFAULT CURRENT_TIMESTAMP,
+    poll_id TEXT,
+    id_unit TEXT,
+    ip_unit TEXT,
+    foreign_address TEXT,
+    foreign_name TEXT,
+    status TEXT,
+    state_counts TEXT,
+    error_class TEXT,
+    error_detail TEXT,
+    attempts INTEGER,
+    attempt_history TEXT,
+    ssh_reachable INTEGER,
+    ssh_latency_ms REAL,
+    reachability TEXT
+);
+
+CREATE TABLE IF NOT EXISTS display_connections (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    poll_id TEXT,
+    date_time DATETIME DEFAULT CURRENT_TIMESTAMP,
+    id_unit TEXT,
+    proto TEXT,
+    recv_q INTEGER,
+    send_q INTEGER,
+    local_address TEXT,
+    local_port TEXT,
+    foreign_address TEXT,
+    foreign_name TEXT,
+    foreign_port TEXT,
+    state TEXT
+);
+
+CREATE TABLE IF NOT EXISTS display_targets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    poll_id TEXT,
+    date_time DATETIME DEFAULT CURRENT_TIMESTAMP,
+    id_unit TEXT,
+    target TEXT,
+    foreign_address TEXT,
+    foreign_name TEXT,
+    status TEXT,
+    state_counts TEXT
+);
+
+CREATE TABLE IF NOT EXISTS unit_breakers (
+    id_unit TEXT PRIMARY KEY,
+    state TEXT,
+    failures INTEGER,
+    opened_at INTEGER,
+    next_probe INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS status_transitions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    poll_id TEXT,
+    id_unit TEXT,
+    from_status TEXT,
+    to_status TEXT,
+    changed_at INTEGER,
+    duration_seconds INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_status_transitions_unit ON status_transitions (id_unit, id);
+
+CREATE TABLE IF NOT EXISTS unit_latest (
+    id_unit TEXT PRIMARY KEY,
+    poll_id TEXT,
+    ip_unit TEXT,
+    status TEXT,
+    foreign_address TEXT,
+    error_class TEXT,
+    last_seen DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS status_hourly (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    id_unit TEXT,
+    hour_start DATETIME,
+    status TEXT,
+    minutes REAL,
+    drops INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_status_hourly_unit ON status_hourly (id_unit, hour_start);
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NameConfig gives a logical name to a foreign endpoint. Units are polled in
+// numeric mode, so names are resolved here instead of by each unit's resolver.
+type NameConfig struct {
+	Name string `json:"name"` // Logical name such as "master"
+	IP   string `json:"ip"`   // IP or CIDR of the endpoint
+	Port string `json:"port"` // Optional, any port when empty
+}
+
+type nameEntry struct {
+	name    string
+	network *net.IPNet
+	port    string
+}
+
+// NameResolver maps raw foreign addresses to logical names
+type NameResolver struct {
+	entries []nameEntry
+}
+
+func newNameResolver(configs []NameConfig) (*NameResolver, error) {
+	r := &NameResolver{}
+	for _, cfg := range configs {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("name entry for %q has no name", cfg.IP)
+		}
+
+		cidr := cfg.IP
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("name %s: invalid IP %q", cfg.Name, cfg.IP)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("name %s: invalid CIDR %q: %v", cfg.Name, cfg.IP, err)
+		}
+
+		r.entries = append(r.entries, nameEntry{name: cfg.Name, network: network, port: cfg.Port})
+	}
+	return r, nil
+}
+
+// Resolve returns the logical name of a connection's foreign endpoint, or ""
+// when no entry matches. Entries are tried in config order.
+func (r *NameResolver) Resolve(conn Connection) string {
+	ip := net.ParseIP(conn.ForeignAddress)
+	if ip == nil {
+		return ""
+	}
+	for _, e := range r.entries {
+		if e.network.Contains(ip) && (e.port == "" || e.port == conn.ForeignPort) {
+			return e.name
+		}
+	}
+	return ""
+}
+
+// unnamedHosts returns the hostname matchers that no names entry can
+// satisfy. Numeric collectors never print host names, so such a matcher only
+// works with the netstat-names collector.
+func (r *NameResolver) unnamedHosts(configs []MatcherConfig) []string {
+	if len(configs) == 0 {
+		configs = defaultMatchers
+	}
+	var unnamed []string
+	for _, cfg := range configs {
+		if cfg.Type != "hostname" {
+			continue
+		}
+		known := false
+		for _, e := range r.entries {
+			if strings.EqualFold(e.name, cfg.Value) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			unnamed = append(unnamed, cfg.Name)
+		}
+	}
+	return unnamed
+}
+
+// Annotate fills in ForeignName on every connection
+func (r *NameResolver) Annotate(connections []Connection) {
+	for i := range connections {
+		connections[i].ForeignName = r.Resolve(connections[i])
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// procStates maps the hex st column of /proc/net/tcp to netstat state names
+var procStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// parseProcNetTCP turns /proc/net/tcp and /proc/net/tcp6 content into
+// connection records. Addresses are hex in host byte order, one 32-bit word
+// at a time, see procByteOrder. This works on units that have no netstat or
+// ss at all.
+func parseProcNetTCP(output []byte) ([]Connection, error) {
+	var connections []Connection
+	var rows [][]string
+	sawHeader := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) > 0 && parts[0] == "sl" {
+			sawHeader = true
+			continue
+		}
+		if len(parts) < 5 || !strings.HasSuffix(parts[0], ":") {
+			continue
+		}
+		rows = append(rows, parts)
+	}
+
+	order := procByteOrder(rows)
+	for _, parts := range rows {
+		localIP, localPort, ok := parseProcAddress(parts[1], order)
+		if !ok {
+			continue
+		}
+		foreignIP, foreignPort, ok := parseProcAddress(parts[2], order)
+		if !ok {
+			continue
+		}
+		state, ok := procStates[strings.ToUpper(parts[3])]
+		if !ok {
+			continue
+		}
+
+		// tx_queue:rx_queue
+		queues := strings.SplitN(parts[4], ":", 2)
+		if len(queues) != 2 {
+			continue
+		}
+		sendQ, err := strconv.ParseInt(queues[0], 16, 64)
+		if err != nil {
+			continue
+		}
+		recvQ, err := strconv.ParseInt(queues[1], 16, 64)
+		if err != nil {
+			continue
+		}
+
+		proto := "tcp"
+		if len(localIP) == net.IPv6len && localIP.To4() == nil {
+			proto = "tcp6"
+		}
+		connections = append(connections, Connection{
+			Proto:          proto,
+			RecvQ:          int(recvQ),
+			SendQ:          int(sendQ),
+			LocalAddress:   localIP.String(),
+			LocalPort:      localPort,
+			ForeignAddress: foreignIP.String(),
+			ForeignPort:    foreignPort,
+			State:          state,
+		})
+	}
+
+	if !sawHeader && len(connections) == 0 {
+		return nil, errUnrecognizedOutput
+	}
+	return connections, nil
+}
+
+// procByteOrder detects the host byte order of a unit from its loopback
+// sockets, which read 0100007F on little-endian units and 7F000001 on
+// big-endian ones. Without any, little-endian is assumed as most units are.
+func procByteOrder(rows [][]string) binary.ByteOrder {
+	for _, parts := range rows {
+		for _, field := range parts[1:3] {
+			addr := field
+			if i := strings.IndexByte(field, ':'); i >= 0 {
+				addr = field[:i]
+			}
+			switch strings.ToUpper(addr) {
+			case "0100007F", "00000000000000000000000001000000":
+				return binary.LittleEndian
+			case "7F000001", "00000000000000000000000000000001":
+				return binary.BigEndian
+			}
+		}
+	}
+	return binary.LittleEndian
+}
+
+// parseProcAddress decodes "0100007F:0016" or its 32 hex digit IPv6 form,
+// each 32-bit word of the address being in the given byte order
+func parseProcAddress(field string, order binary.ByteOrder) (net.IP, string, bool) {
+	i := strings.IndexByte(field, ':')
+	if i < 0 {
+		return nil, "", false
+	}
+	raw, err := hex.DecodeString(field[:i])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, "", false
+	}
+	port, err := strconv.ParseUint(field[i+1:], 16, 16)
+	if err != nil {
+		return nil, "", false
+	}
+
+	ip := make(net.IP, len(raw))
+	for w := 0; w < len(raw); w += 4 {
+		binary.BigEndian.PutUint32(ip[w:], order.Uint32(raw[w:]))
+	}
+	return ip, strconv.FormatUint(port, 10), true
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestParseProcAddress(t *testing.T) {
+	little, big := binary.LittleEndian, binary.BigEndian
+
+	tests := []struct {
+		field string
+		order binary.ByteOrder
+		ip    string
+		port  string
+		ok    bool
+	}{
+		{"0100007F:0016", little, "127.0.0.1", "22", true},
+		{"0500000A:01F6", little, "10.0.0.5", "502", true},
+		{"00000000:0000", little, "0.0.0.0", "0", true},
+		// IPv6 is four 32-bit words in host byte order
+		{"00000000000000000000000001000000:0277", little, "::1", "631", true},
+		{"000080FE000000000000000001000000:0016", little, "fe80::1", "22", true},
+		{"B80D0120000000000000000001000000:01BB", little, "2001:db8::1", "443", true},
+		{"0000000000000000FFFF00000100000A:0016", little, "10.0.0.1", "22", true},
+		{"7F000001:0016", big, "127.0.0.1", "22", true},
+		{"0A000005:01F6", big, "10.0.0.5", "502", true},
+		{"20010DB8000000000000000000000001:01BB", big, "2001:db8::1", "443", true},
+		{"00000000000000000000FFFF0A000001:0016", big, "10.0.0.1", "22", true},
+		{"0100007F", little, "", "", false},
+		{"0100007G:0016", little, "", "", false},
+		{"01007F:0016", little, "", "", false},
+		{"0100007F:10000", little, "", "", false},
+	}
+
+	for _, tt := range tests {
+		ip, port, ok := parseProcAddress(tt.field, tt.order)
+		if ok != tt.ok {
+			t.Errorf("parseProcAddress(%q, %v) ok = %v, want %v", tt.field, tt.order, ok, tt.ok)
+			continue
+		}
+		if ok && (ip.String() != tt.ip || port != tt.port) {
+			t.Errorf("parseProcAddress(%q, %v) = %s, %s, want %s, %s", tt.field, tt.order, ip, port, tt.ip, tt.port)
+		}
+	}
+}
+
+func TestProcByteOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		rows [][]string
+		want binary.ByteOrder
+	}{
+		{name: "little-endian loopback", rows: [][]string{{"0:", "0100007F:0CEA", "00000000:0000"}}, want: binary.LittleEndian},
+		{name: "big-endian loopback", rows: [][]string{{"0:", "0A000005:0016", "0A000001:C822"}, {"1:", "7F000001:0CEA", "00000000:0000"}}, want: binary.BigEndian},
+		{name: "big-endian remote loopback", rows: [][]string{{"0:", "7f000001:9C40", "7f000001:0CEA"}}, want: binary.BigEndian},
+		{name: "big-endian IPv6 loopback", rows: [][]string{{"0:", "00000000000000000000000000000001:0277", "00000000000000000000000000000000:0000"}}, want: binary.BigEndian},
+		{name: "no loopback assumes little-endian", rows: [][]string{{"0:", "0500000A:0016", "0100000A:C822"}}, want: binary.LittleEndian},
+		{name: "no rows", want: binary.LittleEndian},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := procByteOrder(tt.rows)
+			if got != tt.want {
+				t.Errorf("procByteOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProcNetTCP(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Connection
+		err    error
+	}{
+		{
+			name: "tcp and tcp6",
+			output: `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 11111 1 0000000000000000 100 0 0 10 0
+   1: 0500000A:0016 0100000A:C822 01 00000024:00000003 01:00000014 00000000     0        0 22222 4 0000000000000000 20 4 29 10 -1
+  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:0277 00000000000000000000000001000000:9C40 01 00000000:00000000 00:00000000 00000000     0        0 33333 1 0000000000000000 20 4 30 10 -1
+   1: 0000000000000000FFFF00000500000A:01F6 0000000000000000FFFF00000900000A:9C41 02 00000001:00000000 01:00000064 00000000     0        0 44444 2 0000000000000000 20 4 30 10 -1
+`,
+			want: []Connection{
+				{Proto: "tcp", LocalAddress: "0.0.0.0", LocalPort: "22", ForeignAddress: "0.0.0.0", ForeignPort: "0", State: "LISTEN"},
+				{Proto: "tcp", SendQ: 36, RecvQ: 3, LocalAddress: "10.0.0.5", LocalPort: "22", ForeignAddress: "10.0.0.1", ForeignPort: "51234", State: "ESTABLISHED"},
+				{Proto: "tcp6", LocalAddress: "::1", LocalPort: "631", ForeignAddress: "::1", ForeignPort: "40000", State: "ESTABLISHED"},
+				// IPv4-mapped sockets read as plain IPv4
+				{Proto: "tcp", SendQ: 1, LocalAddress: "10.0.0.5", LocalPort: "502", ForeignAddress: "10.0.0.9", ForeignPort: "40001", State: "SYN_SENT"},
+			},
+		},
+		{
+			name: "big-endian unit",
+			output: `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 7F000001:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 11111 1 0000000000000000 100 0 0 10 0
+   1: 0A000005:01F6 0A000009:9C41 01 00000000:00000000 00:00000000 00000000     0        0 22222 1 0000000000000000 20 4 30 10 -1
+`,
+			want: []Connection{
+				{Proto: "tcp", LocalAddress: "127.0.0.1", LocalPort: "3306", ForeignAddress: "0.0.0.0", ForeignPort: "0", State: "LISTEN"},
+				{Proto: "tcp", LocalAddress: "10.0.0.5", LocalPort: "502", ForeignAddress: "10.0.0.9", ForeignPort: "40001", State: "ESTABLISHED"},
+			},
+		},
+		{
+			name: "bad rows are skipped",
+			output: `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0500000A:0016 0100000A:C822 FF 00000000:00000000 00:00000000 00000000     0        0 1
+   1: 0500000A 0100000A:C822 01 00000000:00000000 00:00000000 00000000     0        0 1
+   2: 0500000A:0016 0100000A:C822 01 0000000000000000 00:00000000 00000000     0        0 1
+`,
+		},
+		{
+			name:   "unit without tcp6 prints only the tcp header",
+			output: "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n",
+		},
+		{
+			name:   "not proc output",
+			output: "cat: /proc/net/tcp: No such file or directory\n",
+			err:    errUnrecognizedOutput,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProcNetTCP([]byte(tt.output))
+			if err != tt.err {
+				t.Fatalf("parseProcNetTCP() error = %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProcNetTCP() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Recording modes of display_status
+const (
+	recordAll     = "all"     // One row per poll
+	recordChanges = "changes" // A row only when the unit's state changes
+)
+
+// recordedState is what a display_status row says about a unit, as far as
+// change-only recording is concerned
+type recordedState struct {
+	status         string
+	foreignAddress string
+	errorClass     ErrorClass
+}
+
+// ChangeFilter decides which poll results need a display_status row in
+// change-only recording. It starts empty, so the first poll of each unit
+// after a restart is always recorded. The writer tells it about results that
+// never reached the database, see Forget.
+type ChangeFilter struct {
+	mu    sync.Mutex
+	units map[string]recordedState
+}
+
+// newChangeFilter returns nil in "all" mode, where every poll is recorded
+func newChangeFilter(mode string) (*ChangeFilter, error) {
+	switch mode {
+	case "", recordAll:
+		return nil, nil
+	case recordChanges:
+		return &ChangeFilter{units: make(map[string]recordedState)}, nil
+	default:
+		return nil, fmt.Errorf("unknown recording mode %q, use all or changes", mode)
+	}
+}
+
+// Unchanged reports whether result says the same as the unit's last recorded
+// row, in which case only a heartbeat is written. Otherwise the result
+// becomes the unit's recorded state.
+func (f *ChangeFilter) Unchanged(result PollResult) bool {
+	if f == nil {
+		return false
+	}
+
+	state := recordedState{
+		status:         result.Status,
+		foreignAddress: result.ForeignAddress,
+		errorClass:     result.ErrorClass,
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prev, ok := f.units[result.Server.Alias]
+	if ok && prev == state {
+		return true
+	}
+	f.units[result.Server.Alias] = state
+	return false
+}
+
+// Forget drops what the filter believes about the units of results that were
+// lost, so their next poll writes a full row instead of a heartbeat for a
+// state the database never saw
+func (f *ChangeFilter) Forget(results []PollResult) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range results {
+		delete(f.units, r.Server.Alias)
+	}
+}
+
+// Reset forgets every unit, for losses whose units are not known
+func (f *ChangeFilter) Reset() {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.units = make(map[string]recordedState)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func parseRetryConfig(t *testing.T, data string) RetryConfig {
+	t.Helper()
+	var cfg RetryConfig
+	err := json.Unmarshal([]byte(data), &cfg)
+	if err != nil {
+		t.Fatalf("failed to parse retry config %s: %v", data, err)
+	}
+	return cfg
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		jitter  float64
+		wantErr bool
+	}{
+		{name: "defaults", config: `{}`, jitter: defaultJitter},
+		{name: "jitter turned off", config: `{"jitter": 0}`, jitter: 0},
+		{name: "full jitter", config: `{"jitter": 1}`, jitter: 1},
+		{name: "jitter above 1", config: `{"jitter": 1.5}`, wantErr: true},
+		{name: "negative jitter", config: `{"jitter": -0.1}`, wantErr: true},
+		{name: "known class", config: `{"rules": [{"class": "tcp_timeout", "max_attempts": 4}]}`, jitter: defaultJitter},
+		{name: "unknown class", config: `{"rules": [{"class": "tcp_timout", "max_attempts": 4}]}`, wantErr: true},
+		{name: "empty class", config: `{"rules": [{"max_attempts": 4}]}`, wantErr: true},
+		{name: "suspended is not a poll failure", config: `{"rules": [{"class": "polling_suspended", "max_attempts": 2}]}`, wantErr: true},
+		{name: "zero attempts", config: `{"rules": [{"class": "tcp_timeout", "max_attempts": 0}]}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newRetryPolicy(parseRetryConfig(t, tt.config))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("newRetryPolicy(%s) succeeded, want an error", tt.config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newRetryPolicy(%s) error = %v", tt.config, err)
+			}
+			if p.jitter != tt.jitter {
+				t.Errorf("jitter = %v, want %v", p.jitter, tt.jitter)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p, err := newRetryPolicy(parseRetryConfig(t, `{"initial_backoff": "2s", "max_backoff": "30s", "multiplier": 2, "jitter": 0}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 16 * time.Second},
+		{5, 30 * time.Second},
+		{50, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		got := p.backoff(tt.attempt)
+		if got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestRetryBackoffJitter(t *testing.T) {
+	p, err := newRetryPolicy(parseRetryConfig(t, `{"initial_backoff": "10s", "max_backoff": "10s", "jitter": 0.5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		got := p.backoff(3)
+		if got < 5*time.Second || got > 15*time.Second {
+			t.Fatalf("backoff(3) = %v, want between 5s and 15s", got)
+		}
+	}
+}
+
+func TestRetryAttemptsFor(t *testing.T) {
+	p, err := newRetryPolicy(parseRetryConfig(t, `{
+		"max_attempts": 3,
+		"rules": [
+			{"class": "auth_rejected", "max_attempts": 2},
+			{"class": "tcp_timeout", "max_attempts": 5},
+			{"class": "network_error", "max_attempts": 1}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		class ErrorClass
+		want  int
+	}{
+		{ClassConnectionRefused, 3},
+		{ClassTCPTimeout, 5},
+		{ClassNetworkError, 1},
+		{ClassAuthRejected, 2},    // A rule overrides a class that is not retried by default
+		{ClassHostKeyMismatch, 1}, // Not retried
+		{ClassCommandNotFound, 1},
+	}
+
+	for _, tt := range tests {
+		got := p.attemptsFor(tt.class)
+		if got != tt.want {
+			t.Errorf("attemptsFor(%s) = %d, want %d", tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget int
+		takes  int
+		want   int // Retries granted
+	}{
+		{name: "unlimited", budget: 0, takes: 100, want: 100},
+		{name: "within budget", budget: 5, takes: 3, want: 3},
+		{name: "budget spent", budget: 5, takes: 8, want: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newRetryPolicy(RetryConfig{Budget: tt.budget})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Every cycle starts with a full budget
+			for cycle := 0; cycle < 2; cycle++ {
+				ctx := p.withBudget(context.Background())
+				granted := 0
+				for i := 0; i < tt.takes; i++ {
+					if takeRetry(ctx) {
+						granted++
+					}
+				}
+				if granted != tt.want {
+					t.Errorf("cycle %d: granted %d retries, want %d", cycle+1, granted, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// shutdownContexts returns the two contexts used for a graceful stop. stop is
+// done as soon as SIGINT or SIGTERM arrives, after which no new unit is
+// started. work is done once the grace period after the signal has passed,
+// which cancels polls still in flight. release must be called on exit.
+func shutdownContexts(grace time.Duration) (stop, work context.Context, release func()) {
+	if grace <= 0 {
+		grace = defaultShutdownTimeout
+	}
+
+	stop, stopCancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	work, workCancel := context.WithCancel(context.Background())
+
+	go func() {
+		<-stop.Done()
+		if work.Err() != nil {
+			return
+		}
+		log.Printf("Shutting down, waiting up to %s for in-flight polls", grace)
+		select {
+		case <-time.After(grace):
+			log.Println("Grace period over, cancelling in-flight polls")
+		case <-work.Done():
+		}
+		workCancel()
+	}()
+
+	release = func() {
+		workCancel()
+		stopCancel()
+	}
+	return stop, work, release
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// ssStates maps the state names of ss to the ones netstat prints, which is
+// what matchers and display_status expect
+var ssStates = map[string]string{
+	"ESTAB":      "ESTABLISHED",
+	"SYN-SENT":   "SYN_SENT",
+	"SYN-RECV":   "SYN_RECV",
+	"FIN-WAIT-1": "FIN_WAIT1",
+	"FIN-WAIT-2": "FIN_WAIT2",
+	"TIME-WAIT":  "TIME_WAIT",
+	"CLOSE-WAIT": "CLOSE_WAIT",
+	"LAST-ACK":   "LAST_ACK",
+	"LISTEN":     "LISTEN",
+	"CLOSING":    "CLOSING",
+	"UNCONN":     "CLOSE",
+}
+
+// parseSS turns `ss -tan` output into connection records. Older ss versions
+// print a leading Netid column, and IPv6 addresses may or may not be bracketed.
+func parseSS(output []byte) ([]Connection, error) {
+	var connections []Connection
+	sawHeader := false
+	offset := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) == 0 {
+			continue
+		}
+		if parts[0] == "State" || parts[0] == "Netid" {
+			sawHeader = true
+			if parts[0] == "Netid" {
+				offset = 1
+			}
+			continue
+		}
+		if len(parts) < offset+5 {
+			continue
+		}
+
+		state, ok := ssStates[parts[offset]]
+		if !ok {
+			continue
+		}
+		recvQ, err := strconv.Atoi(parts[offset+1])
+		if err != nil {
+			continue
+		}
+		sendQ, err := strconv.Atoi(parts[offset+2])
+		if err != nil {
+			continue
+		}
+
+		conn := Connection{
+			Proto: "tcp",
+			RecvQ: recvQ,
+			SendQ: sendQ,
+			State: state,
+		}
+		conn.LocalAddress, conn.LocalPort = splitAddress(parts[offset+3])
+		conn.ForeignAddress, conn.ForeignPort = splitAddress(parts[offset+4])
+		conn.LocalAddress = strings.Trim(conn.LocalAddress, "[]")
+		conn.ForeignAddress = strings.Trim(conn.ForeignAddress, "[]")
+		if strings.Contains(conn.LocalAddress, ":") {
+			conn.Proto = "tcp6"
+		}
+
+		connections = append(connections, conn)
+	}
+
+	if !sawHeader && len(connections) == 0 {
+		return nil, errUnrecognizedOutput
+	}
+	return connections, nil
+}
@@ -1,18 +1,17 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"strings"
+	"os"
 	"sync"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql" // MySQL driver
 	"golang.org/x/crypto/ssh"
 )
 
@@ -27,57 +26,276 @@ type IPField struct {
 	Valid  bool   `json:"Valid"`
 }
 
+// Collector holds everything needed to poll a unit
+type Collector struct {
+	writer      *ResultWriter
+	matchers    []*Matcher
+	creds       *Credentials
+	hostKeys    *HostKeyStore
+	groups      []*UnitGroup
+	jumpHosts   map[string][]*JumpHost // Hop chain per group name
+	retry       *RetryPolicy
+	breakers    *Breakers
+	transitions *TransitionTracker
+	changes     *ChangeFilter  // Change-only recording, nil when every poll is recorded
+	strategies  *StrategyCache // Connection listing command per unit
+	names       *NameResolver
+	reachProbe  *ReachProbe // Collector-side TCP probe, nil when disabled
+
+	commandTimeout time.Duration // Deadline for the remote command, the session is closed after it
+	maxOutput      int           // Cap on captured command output in bytes
+}
+
 // Configurations
 const (
-	maxConcurrentConnections = 150             // Max number of concurrent SSH connections
-	maxRetries               = 2               // Max number of retries for SSH connection
-	batchSize                = 100             // Number of records to insert in a single batch
-	sshTimeout               = 5 * time.Second // SSH connection timeout
+	maxConcurrentConnections = 150              // Max number of concurrent SSH connections
+	maxRetries               = 2                // Default attempts per unit, see RetryConfig
+	batchSize                = 100              // Number of records to insert in a single batch
+	sshTimeout               = 5 * time.Second  // SSH connection timeout
+	maxErrorDetail           = 1024             // Max length of the stored error detail
+	commandTimeout           = 30 * time.Second // Default deadline for the remote command
+	maxOutputBytes           = 4 << 20          // Default cap on captured command output
 )
 
+// Legacy shared login, only used when no credentials file is configured
 var (
 	defaultUsername = "username"
 	defaultPassword = "password"
 )
 
 func main() {
-	// Open MySQL database
-	db, err := sql.Open("mysql", "username:password@tcp(IP:port)/db_name")
+	configPath := flag.String("config", "config.json", "Path to the collector config file")
+	daemon := flag.Bool("daemon", false, "Keep running and poll units on the configured schedule")
+	retain := flag.Bool("retention", false, "Run the retention job once and exit")
+	var check checkOptions
+	flag.StringVar(&check.ip, "ip", "", "Poll this IP only and print the result, without the database")
+	flag.StringVar(&check.units, "units", "", "Comma-separated inventory aliases to poll and print, without the database")
+	flag.StringVar(&check.hostsFile, "hosts", "", "File of units to poll and print, without the database")
+	flag.StringVar(&check.output, "output", "table", "Output format of -ip, -units and -hosts: table or json")
+	flag.Parse()
+
+	// Load collector config and build the target matchers
+	cfg, err := loadConfig(*configPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
+	matchers, err := newMatchers(cfg.Matchers)
+	if err != nil {
+		log.Fatalf("Invalid matcher config: %v", err)
+	}
+	groups, err := newUnitGroups(cfg.Groups)
+	if err != nil {
+		log.Fatalf("Invalid group config: %v", err)
+	}
+	creds, err := loadCredentials(cfg.CredentialsFile, groups, defaultUsername, defaultPassword)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hostKeys, err := newHostKeyStore(cfg.HostKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	jumpHosts, err := newJumpHosts(cfg.Groups, creds)
+	if err != nil {
+		log.Fatalf("Invalid jump host config: %v", err)
+	}
+	names, err := newNameResolver(cfg.Names)
+	if err != nil {
+		log.Fatalf("Invalid names config: %v", err)
+	}
+	collectors, err := collectorNames(cfg.Collectors, names.unnamedHosts(cfg.Matchers))
+	if err != nil {
+		log.Fatalf("Invalid collectors config: %v", err)
+	}
+	strategies, err := newStrategyCache(collectors)
+	if err != nil {
+		log.Fatalf("Invalid collectors config: %v", err)
+	}
+	changes, err := newChangeFilter(cfg.Recording)
+	if err != nil {
+		log.Fatal(err)
+	}
+	retention, err := newRetentionPolicy(cfg.Retention)
+	if err != nil {
+		log.Fatalf("Invalid retention config: %v", err)
+	}
+	retry, err := newRetryPolicy(cfg.Retry)
+	if err != nil {
+		log.Fatalf("Invalid retry config: %v", err)
+	}
 
-	// Create server data table if not exists
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS display_status (
-        id INT AUTO_INCREMENT PRIMARY KEY,
-		date_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-        id_unit VARCHAR(255),
-        ip_unit VARCHAR(255),
-        foreign_address VARCHAR(255),
-        status VARCHAR(255)
-    );`)
+	collector := &Collector{
+		matchers:   matchers,
+		creds:      creds,
+		hostKeys:   hostKeys,
+		groups:     groups,
+		jumpHosts:  jumpHosts,
+		retry:      retry,
+		strategies: strategies,
+		names:      names,
+		reachProbe: newReachProbe(cfg.Reach),
+		changes:    changes,
+
+		commandTimeout: time.Duration(cfg.CommandTimeout),
+		maxOutput:      cfg.MaxOutputBytes,
+	}
+	if collector.commandTimeout <= 0 {
+		collector.commandTimeout = commandTimeout
+	}
+	if collector.maxOutput <= 0 {
+		collector.maxOutput = maxOutputBytes
+	}
+
+	// Unit inventory, by default the central /ipunit endpoint only
+	inventory, err := newInventory(cfg.Inventory, "http://localhost:port/ipunit")
+	if err != nil {
+		log.Fatalf("Invalid inventory config: %v", err)
+	}
+
+	stop, work, release := shutdownContexts(time.Duration(cfg.ShutdownTimeout))
+	defer release()
+
+	// Check mode polls the given units, prints them and never opens the database
+	if check.enabled() {
+		code := runCheck(stop, work, collector, inventory, check)
+		release()
+		os.Exit(code)
+	}
+
+	// Open the configured database
+	store, err := newStore(cfg.Database)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer store.Close()
+
+	// "migrate up|down|status" manages the schema and exits; any other run
+	// applies pending migrations first
+	if flag.Arg(0) == "migrate" {
+		code := runMigrate(store, flag.Arg(1))
+		release()
+		store.Close()
+		os.Exit(code)
+	}
+	// Retention mode runs the retention job alone, e.g. from cron
+	if *retain {
+		if retention == nil {
+			log.Fatal("Retention is not configured, set retention.raw_days")
+		}
+		// Leave hours spooled results will land in to a run after the replay
+		spool, err := openSpool(cfg.Spool)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if spool != nil && spool.Pending() {
+			log.Printf("Spool %s still holds results, skipping this retention run", spool.dir)
+			return
+		}
+		err = store.Init()
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = store.Retain(stop, retention)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	// Fetch server list from API
-	servers, err := fetchServerList("http://localhost:port/ipunit")
+	// With a spool, a database that is down at startup does not stop polling:
+	// units start with closed breakers and no known status, and results are
+	// spooled until the database is back
+	spool, err := openSpool(cfg.Spool)
+	if err != nil {
+		log.Fatal(err)
+	}
+	collector.breakers = newBreakers(cfg.Breaker, store)
+	collector.transitions = newTransitionTracker()
+	initErr := store.Init()
+	err = initErr
+	if err == nil {
+		err = collector.breakers.Load(context.Background())
+		if err != nil {
+			err = fmt.Errorf("failed to load breaker states: %v", err)
+		}
+	}
+	if err == nil {
+		err = collector.transitions.Load(context.Background(), store)
+		if err != nil {
+			err = fmt.Errorf("failed to load unit statuses: %v", err)
+		}
+	}
 	if err != nil {
-		log.Fatalf("Failed to fetch server list: %v", err)
+		if spool == nil {
+			log.Fatal(err)
+		}
+		log.Printf("Database unavailable, spooling results to %s: %v", spool.dir, err)
 	}
 
+	// Results are written in batches by a single writer stage
+	writer := newResultWriter(store, spool, changes, initErr == nil, batchSize, time.Duration(cfg.FlushInterval))
+	collector.writer = writer
+
+	if *daemon {
+		if cfg.MetricsAddr != "" {
+			serveMetrics(cfg.MetricsAddr)
+		}
+		scheduler, err := newScheduler(cfg.Daemon, collector.retry, inventory.Servers, collector.connectToServer)
+		if err != nil {
+			log.Fatalf("Invalid daemon config: %v", err)
+		}
+		retained := make(chan struct{})
+		go func() {
+			defer close(retained)
+			if retention != nil {
+				runRetentionLoop(stop, store, retention, spool)
+			}
+		}()
+		scheduler.Run(stop, work)
+		<-retained
+	} else {
+		// Fetch and merge the server list from every inventory source
+		servers, err := inventory.Servers(stop)
+		if err != nil {
+			log.Fatalf("Failed to fetch server list: %v", err)
+		}
+
+		cycleWork := collector.retry.withBudget(work)
+		runCycle(stop, servers, func(server Server) {
+			collector.connectToServer(cycleWork, server)
+		})
+	}
+
+	// Flush buffered results before exiting, bounded by the shutdown timeout
+	closeTimeout := time.Duration(cfg.ShutdownTimeout)
+	if closeTimeout <= 0 {
+		closeTimeout = defaultShutdownTimeout
+	}
+	flushCtx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	writer.Close(flushCtx)
+}
+
+// runCycle is the per-cycle worker pool: it polls every server once with at
+// most maxConcurrentConnections polls in flight. Once ctx is done no further
+// server is started; polls already running are left to finish.
+func runCycle(ctx context.Context, servers []Server, poll func(server Server)) {
 	// Create a buffered channel to limit concurrent connections
 	concurrencyLimiter := make(chan struct{}, maxConcurrentConnections)
 
 	var wg sync.WaitGroup
 
 	for _, server := range servers {
+		select {
+		case concurrencyLimiter <- struct{}{}: // Acquire a token
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
 		wg.Add(1)
-		concurrencyLimiter <- struct{}{} // Acquire a token
 		go func(server Server) {
 			defer wg.Done()
-			connectToServer(db, server, defaultUsername, defaultPassword)
+			poll(server)
 			<-concurrencyLimiter // Release the token
 		}(server)
 	}
@@ -85,13 +303,21 @@ func main() {
 	wg.Wait()
 }
 
-func fetchServerList(apiURL string) ([]Server, error) {
-	resp, err := http.Get(apiURL)
+func fetchServerList(ctx context.Context, apiURL string) ([]Server, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch server list: %v", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("failed to fetch server list: %s", resp.Status)
+	}
+
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %v", err)
@@ -106,103 +332,219 @@ func fetchServerList(apiURL string) ([]Server, error) {
 	return servers, nil
 }
 
-func connectToServer(db *sql.DB, server Server, username, password string) {
+// connectToServer polls one unit, stores the result and returns the stored status.
+// A poll cut short by ctx is not stored, it says nothing about the unit. Units
+// with suspended polling are not polled until their next probe is due, but
+// are still reach probed so a unit back on the network shows up.
+func (c *Collector) connectToServer(ctx context.Context, server Server) string {
+	if !c.breakers.Allow(server.Alias, time.Now()) {
+		reach := c.reach(ctx, server)
+		if ctx.Err() == nil && reach != nil {
+			c.insertDataToDatabase(ctx, suspendedResult(server, reach))
+		}
+		return ""
+	}
+
+	result := c.pollAndReach(ctx, server)
+	if ctx.Err() != nil {
+		log.Printf("Poll of %s (%s) cancelled\n", server.Alias, server.IP.String)
+		return ""
+	}
+	// The transition is stamped with the poll time, which the row's date_time is written from
+	result.PolledAt = time.Now()
+	c.breakers.Record(ctx, server.Alias, result.ErrorClass, result.PolledAt)
+	result.Transition = c.transitions.Observe(server.Alias, result.Status, result.PolledAt)
+	recordPollMetrics(result)
+	c.insertDataToDatabase(ctx, result)
+	return result.Status
+}
+
+// pollAndReach polls a unit and reach probes it at the same time, so the
+// probe costs no extra time in the caller's worker slot
+func (c *Collector) pollAndReach(ctx context.Context, server Server) PollResult {
+	var reach []PortReach
+	reached := make(chan struct{})
+	go func() {
+		reach = c.reach(ctx, server)
+		close(reached)
+	}()
+	result := c.pollServer(ctx, server)
+	<-reached
+	result.Reach = reach
+	return result
+}
+
+// pollServer runs netstat on a unit, retrying failures as the retry policy allows
+func (c *Collector) pollServer(ctx context.Context, server Server) PollResult {
 	if !server.IP.Valid {
 		log.Printf("Invalid IP for %s (%s)", server.Alias, server.IP.String)
-		insertDataToDatabase(db, server, "", "Invalid IP")
-		return
+		return failedResult(server, newPollError(ClassInvalidIP, fmt.Errorf("invalid IP %q", server.IP.String)), nil)
 	}
 
-	retryCount := 0
+	credential, err := c.creds.Resolve(server.Alias, server.IP.String)
+	if err != nil {
+		log.Printf("No usable credentials for %s (%s): %v", server.Alias, server.IP.String, err)
+		return failedResult(server, newPollError(ClassNoCredentials, err), nil)
+	}
 
-	for {
-		fmt.Printf("Connecting to %s (%s)...\n", server.Alias, server.IP.String)
+	var attempts []Attempt
 
-		// SSH connection configuration with password authentication
-		config := &ssh.ClientConfig{
-			User: username,
-			Auth: []ssh.AuthMethod{
-				ssh.Password(password),
-			},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(), // Use only in testing environments
-			Timeout:         sshTimeout,
-		}
+	for attempt := 1; ; attempt++ {
+		log.Printf("Connecting to %s (%s)...\n", server.Alias, server.IP.String)
 
-		// Connect to the remote server
-		client, err := ssh.Dial("tcp", server.IP.String+":22", config)
-		if err != nil {
-			log.Printf("Failed to dial to %s (%s): %v\n", server.Alias, server.IP.String, err)
-			retryCount++
-			if retryCount >= maxRetries {
-				insertDataToDatabase(db, server, "", "Failed to Connect")
-				return
+		start := time.Now()
+		connections, strategy, pollErr := c.runNetstat(ctx, server, credential)
+		if pollErr == nil {
+			if attempt > 1 {
+				log.Printf("Connected to %s (%s) on attempt %d\n", server.Alias, server.IP.String, attempt)
+			}
+			// Name the foreign endpoints, then resolve each configured target.
+			// The first matcher is the primary link and keeps filling display_status.
+			c.names.Annotate(connections)
+			targets := matchTargets(c.matchers, connections)
+			primary := targets[0]
+			return PollResult{
+				Server:         server,
+				ForeignAddress: primary.ForeignAddress,
+				ForeignName:    primary.ForeignName,
+				Status:         primary.Status,
+				StateCounts:    primary.States,
+				Strategy:       strategy,
+				Connections:    connections,
+				Targets:        targets,
+				Attempts:       append(attempts, newAttempt(attempt, start, nil)),
 			}
-			time.Sleep(5 * time.Second) // Wait before retrying
-			continue
 		}
+		attempts = append(attempts, newAttempt(attempt, start, pollErr))
 
-		// Create a session
-		session, err := client.NewSession()
-		if err != nil {
-			log.Printf("Failed to create session for %s (%s): %v\n", server.Alias, server.IP.String, err)
-			client.Close()
-			retryCount++
-			if retryCount >= maxRetries {
-				insertDataToDatabase(db, server, "", "Failed to Connect")
-				return
-			}
-			time.Sleep(5 * time.Second) // Wait before retrying
-			continue
+		if attempt >= c.retry.attemptsFor(pollErr.Class) {
+			return failedResult(server, pollErr, attempts)
 		}
+		if !takeRetry(ctx) {
+			log.Printf("Retry budget of this cycle used up, not retrying %s (%s)\n", server.Alias, server.IP.String)
+			return failedResult(server, pollErr, attempts)
+		}
+		pollRetries.Inc()
 
-		// Execute the netstat command
-		output, err := session.CombinedOutput("netstat")
-		if err != nil {
-			log.Printf("Failed to execute command on %s (%s): %v\n", server.Alias, server.IP.String, err)
-			session.Close()
-			client.Close()
-			retryCount++
-			if retryCount >= maxRetries {
-				insertDataToDatabase(db, server, "", "Failed to Execute Command")
-				return
-			}
-			time.Sleep(5 * time.Second) // Wait before retrying
-			continue
-		}
-
-		// Close session and client
-		session.Close()
-		client.Close()
-
-		// Process the output to find lines containing "master" in foreign address column
-		var foreignAddress, statusOutput string
-		lines := bytes.Split(output, []byte("\n"))
-		for _, line := range lines {
-			if strings.Contains(string(line), "master") {
-				parts := strings.Fields(string(line)) // Split by any whitespace
-				for _, part := range parts {
-					if strings.Contains(part, "master") {
-						foreignAddress = part
-					} else if part == "ESTABLISHED" || part == "SYN_SENT" {
-						statusOutput = part
-					}
-				}
-				break
-			}
+		// Wait before retrying, unless we are shutting down
+		select {
+		case <-time.After(c.retry.backoff(attempt)):
+		case <-ctx.Done():
+			return failedResult(server, pollErr, attempts)
+		}
+	}
+}
+
+// runNetstat makes a single attempt: dial the unit, then list its connections
+// with the first collector strategy the unit supports. It returns the name of
+// the strategy that worked.
+func (c *Collector) runNetstat(ctx context.Context, server Server, credential *Credential) ([]Connection, string, *PollError) {
+	auth, closeAuth, err := credential.AuthMethods()
+	if err != nil {
+		log.Printf("Failed to prepare auth for %s (%s): %v", server.Alias, server.IP.String, err)
+		return nil, "", newPollError(ClassNoCredentials, err)
+	}
+
+	// SSH connection configuration with the unit's resolved credential
+	config := &ssh.ClientConfig{
+		User:            credential.Username,
+		Auth:            auth,
+		HostKeyCallback: c.hostKeys.Callback(),
+		Timeout:         sshTimeout,
+	}
+
+	// Connect to the remote server, through its jump hosts if it has any
+	dialStart := time.Now()
+	client, closeClient, pollErr := c.dialUnit(ctx, server, config)
+	sshDialDuration.Observe(time.Since(dialStart).Seconds())
+	closeAuth()
+	if pollErr != nil {
+		switch pollErr.Class {
+		case ClassHostKeyMismatch:
+			// Never ignore a changed key, the unit may have been swapped or spoofed
+			log.Printf("Host key mismatch for %s (%s): %v\n", server.Alias, server.IP.String, pollErr.Err)
+		case ClassGatewayFailure:
+			log.Printf("Gateway failure for %s (%s): %v\n", server.Alias, server.IP.String, pollErr.Err)
+		default:
+			log.Printf("Failed to dial to %s (%s): %v\n", server.Alias, server.IP.String, pollErr.Err)
 		}
+		return nil, "", pollErr
+	}
+	defer closeClient()
 
-		// Store data in the database
-		insertDataToDatabase(db, server, foreignAddress, statusOutput)
+	for _, strategy := range c.strategies.For(server.Alias) {
+		var connections []Connection
+		connections, pollErr = c.runStrategy(ctx, client, server, strategy)
+		if pollErr == nil {
+			c.strategies.Remember(server.Alias, strategy)
+			return connections, strategy.Name, nil
+		}
 
-		return
+		// A missing command or unexpected output means the unit does not
+		// support this strategy; anything else is a problem with the unit
+		switch pollErr.Class {
+		case ClassCommandNotFound, ClassCommandFailed, ClassParseFailure:
+			c.strategies.Forget(server.Alias, strategy)
+			log.Printf("Collector %s not usable on %s (%s): %v\n", strategy.Name, server.Alias, server.IP.String, pollErr.Err)
+		default:
+			return nil, "", pollErr
+		}
 	}
+	return nil, "", pollErr
 }
 
-func insertDataToDatabase(db *sql.DB, server Server, foreignAddress, statusOutput string) {
-	_, err := db.Exec("INSERT INTO display_status (id_unit, ip_unit, foreign_address, status) VALUES (?, ?, ?, ?)", server.Alias, server.IP.String, foreignAddress, statusOutput)
+// runStrategy runs one strategy's command in a new session and parses its output
+func (c *Collector) runStrategy(ctx context.Context, client *ssh.Client, server Server, strategy *Strategy) ([]Connection, *PollError) {
+	session, err := client.NewSession()
 	if err != nil {
-		log.Printf("Failed to insert data for %s (%s) into database: %v\n", server.Alias, server.IP.String, err)
-	} else {
-		log.Printf("Data inserted successfully for %s (%s) into database\n", server.Alias, server.IP.String)
+		log.Printf("Failed to create session for %s (%s): %v\n", server.Alias, server.IP.String, err)
+		return nil, newPollError(ClassSessionFailure, err)
+	}
+	defer session.Close()
+
+	// Execute the command, bounded in time and output size
+	commandCtx, cancel := context.WithTimeout(ctx, c.commandTimeout)
+	defer cancel()
+	commandStart := time.Now()
+	output, err := runCommand(commandCtx, session, strategy.Command, c.maxOutput)
+	sshCommandDuration.Observe(time.Since(commandStart).Seconds())
+	if err != nil {
+		log.Printf("Failed to execute %q on %s (%s): %v\n", strategy.Command, server.Alias, server.IP.String, err)
+		return nil, classifyCommandError(err)
+	}
+
+	connections, err := strategy.Parse(output)
+	if err != nil {
+		return nil, newPollError(ClassParseFailure, fmt.Errorf("%s: %v", strategy.Name, err))
 	}
+	return connections, nil
+}
+
+// suspendedResult is the stored result of a unit with suspended polling,
+// which only carries its reach probe
+func suspendedResult(server Server, reach []PortReach) PollResult {
+	return PollResult{
+		Server:     server,
+		Status:     "Polling Suspended",
+		ErrorClass: ClassPollingSuspended,
+		Reach:      reach,
+	}
+}
+
+// failedResult is the stored result of a poll that ended in pollErr
+func failedResult(server Server, pollErr *PollError, attempts []Attempt) PollResult {
+	return PollResult{
+		Server:      server,
+		Status:      pollErr.Status(),
+		ErrorClass:  pollErr.Class,
+		ErrorDetail: pollErr.Detail(),
+		Attempts:    attempts,
+	}
+}
+
+// insertDataToDatabase hands a poll result to the batch writer. In change-only
+// recording a result that changes nothing is written as a heartbeat.
+func (c *Collector) insertDataToDatabase(ctx context.Context, result PollResult) {
+	result.Heartbeat = c.changes.Unchanged(result)
+	c.writer.Write(ctx, result)
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	_ "github.com/go-sql-driver/mysql" // MySQL driver
+)
+
+var mysqlDialect = dialect{
+	driver: "mysql",
+	columns: []column{
+		{"display_status", "poll_id", "VARCHAR(32)"},
+		{"display_status", "error_class", "VARCHAR(64)"},
+		{"display_status", "error_detail", "VARCHAR(1024)"},
+		{"display_status", "attempts", "INT"},
+		{"display_status", "attempt_history", "TEXT"},
+		{"display_status", "foreign_name", "VARCHAR(255)"},
+		{"display_connections", "foreign_name", "VARCHAR(255)"},
+		{"display_targets", "poll_id", "VARCHAR(32)"}, // Tables of the mysql collector linked targets by status_id
+		{"display_targets", "foreign_name", "VARCHAR(255)"},
+		{"display_status", "state_counts", "TEXT"},
+		{"display_targets", "state_counts", "TEXT"},
+		{"display_status", "ssh_reachable", "BOOLEAN"},
+		{"display_status", "ssh_latency_ms", "DOUBLE"},
+		{"display_status", "reachability", "TEXT"},
+	},
+	latestQuery: `
+		SELECT ds.id, ds.date_time, ds.id_unit, ds.ip_unit, ds.foreign_address, ds.status, COALESCE(ds.error_class, '')
+		FROM display_status ds
+		INNER JOIN (` + latestStatusIDs("1 = 1") + `
+		) latest ON ds.id = latest.id
+		ORDER BY ds.id_unit;
+	`,
+	upsertBreaker: `
+		INSERT INTO unit_breakers (id_unit, state, failures, opened_at, next_probe)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			state = VALUES(state), failures = VALUES(failures),
+			opened_at = VALUES(opened_at), next_probe = VALUES(next_probe);
+	`,
+	upsertLatest: `
+		INSERT INTO unit_latest (id_unit, poll_id, ip_unit, status, foreign_address, error_class, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			poll_id = VALUES(poll_id), ip_unit = VALUES(ip_unit), status = VALUES(status),
+			foreign_address = VALUES(foreign_address), error_class = VALUES(error_class), last_seen = VALUES(last_seen);
+	`,
+	unixNow: "UNIX_TIMESTAMP()",
+}
+
+func newMySQLStore(dsn string) (Store, error) {
+	if dsn == "" {
+		dsn = "username:password@tcp(IP:port)/db_name"
+	}
+	return openSQLStore(mysqlDialect, dsn)
+}
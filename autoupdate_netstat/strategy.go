@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Strategy is one way of listing a unit's TCP connections: a command and the
+// parser for its output. Every parser yields the same Connection records.
+type Strategy struct {
+	Name    string
+	Command string
+	Parse   func(output []byte) ([]Connection, error)
+}
+
+// strategies are the collectors that can be named in the config
+var strategies = map[string]*Strategy{
+	"ss":            {Name: "ss", Command: "ss -tan", Parse: parseSS},
+	"netstat":       {Name: "netstat", Command: "netstat -tan", Parse: parseNetstat},
+	"netstat-names": {Name: "netstat-names", Command: "netstat", Parse: parseNetstat},
+	// tcp6 is missing on units without IPv6; the trailing true keeps that from
+	// failing the command, a missing tcp still fails in the parser
+	"proc": {Name: "proc", Command: "cat /proc/net/tcp; cat /proc/net/tcp6 2>/dev/null; true", Parse: parseProcNetTCP},
+}
+
+// defaultStrategies are all numeric: names are resolved on the collector from
+// the names config, so a unit's broken /etc/hosts cannot hide its link.
+// netstat-names, which resolves on the unit, is only used when configured.
+var defaultStrategies = []string{"ss", "netstat", "proc"}
+
+// collectorNames checks the configured strategies against the hostname
+// matchers no names entry covers. Those can only match names resolved on the
+// unit, so they need netstat-names in the configured list; it is never added
+// behind the operator's back.
+func collectorNames(configured, unnamedHosts []string) ([]string, error) {
+	if len(unnamedHosts) == 0 {
+		return configured, nil
+	}
+	for _, name := range configured {
+		if name == "netstat-names" {
+			return configured, nil
+		}
+	}
+	return nil, fmt.Errorf("hostname matchers %s have no names entry and no configured collector resolves host names, add names entries or list netstat-names in collectors", strings.Join(unnamedHosts, ", "))
+}
+
+// StrategyCache remembers which strategy worked on each unit, so detection
+// only costs extra sessions on the first poll or after a unit is reimaged
+type StrategyCache struct {
+	order []*Strategy
+
+	mu     sync.Mutex
+	byUnit map[string]*Strategy
+}
+
+func newStrategyCache(names []string) (*StrategyCache, error) {
+	if len(names) == 0 {
+		names = defaultStrategies
+	}
+
+	c := &StrategyCache{byUnit: make(map[string]*Strategy)}
+	for _, name := range names {
+		st, ok := strategies[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q, use ss, netstat, netstat-names or proc", name)
+		}
+		c.order = append(c.order, st)
+	}
+	return c, nil
+}
+
+// For returns the strategies to try on a unit: the one that worked last
+// time first, then the rest in configured order
+func (c *StrategyCache) For(alias string) []*Strategy {
+	c.mu.Lock()
+	cached := c.byUnit[alias]
+	c.mu.Unlock()
+
+	if cached == nil {
+		return c.order
+	}
+	order := []*Strategy{cached}
+	for _, st := range c.order {
+		if st != cached {
+			order = append(order, st)
+		}
+	}
+	return order
+}
+
+// Remember records the strategy that worked on a unit
+func (c *StrategyCache) Remember(alias string, st *Strategy) {
+	c.mu.Lock()
+	c.byUnit[alias] = st
+	c.mu.Unlock()
+}
+
+// Forget drops a unit's cached strategy after it stopped working
+func (c *StrategyCache) Forget(alias string, st *Strategy) {
+	c.mu.Lock()
+	if c.byUnit[alias] == st {
+		delete(c.byUnit, alias)
+	}
+	c.mu.Unlock()
+}
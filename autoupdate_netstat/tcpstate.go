@@ -0,0 +1,31 @@
+package main
+
+// tcpStatePrecedence ranks the states a connection to a target can be in,
+// strongest first. A unit may hold several connections to the same target,
+// e.g. a fresh ESTABLISHED one next to old ones in TIME_WAIT, and the
+// strongest state is the one reported for the target: a working link wins,
+// then one being set up, then the half-closed states where the unit still
+// holds the socket (CLOSE_WAIT first, the application has not closed it),
+// and TIME_WAIT last as it is only the kernel's leftover.
+var tcpStatePrecedence = []string{
+	"ESTABLISHED",
+	"SYN_SENT",
+	"SYN_RECV",
+	"CLOSE_WAIT",
+	"FIN_WAIT1",
+	"FIN_WAIT2",
+	"LAST_ACK",
+	"CLOSING",
+	"TIME_WAIT",
+}
+
+// tcpStateRank returns the position of state in tcpStatePrecedence. LISTEN,
+// CLOSE and unknown states are not tracked and return false.
+func tcpStateRank(state string) (int, bool) {
+	for i, s := range tcpStatePrecedence {
+		if s == state {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Transition is a change of a unit's display_status.status between two polls,
+// stored in status_transitions so the APIs need not rebuild it from raw rows
+type Transition struct {
+	Unit      string
+	From      string
+	To        string
+	ChangedAt int64 // Unix seconds of the poll that saw To
+	Duration  int64 // Seconds the unit spent in From, -1 when unknown
+}
+
+// unitStatus is a unit's current status and since when it holds
+type unitStatus struct {
+	status string
+	since  int64 // Unix seconds, 0 when unknown
+}
+
+// TransitionTracker remembers the last status of every unit
+type TransitionTracker struct {
+	mu    sync.Mutex
+	units map[string]unitStatus
+}
+
+// newTransitionTracker returns a tracker that knows no unit yet, see Load
+func newTransitionTracker() *TransitionTracker {
+	return &TransitionTracker{units: make(map[string]unitStatus)}
+}
+
+// Load restores the current status of every unit, from its latest transition
+// or else from its latest display_status row, so a restart does not log a
+// transition for every unit
+func (t *TransitionTracker) Load(ctx context.Context, store Store) error {
+	latest, err := store.LatestStatus(ctx)
+	if err != nil {
+		return err
+	}
+	transitions, err := store.LatestTransitions(ctx)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, row := range latest {
+		if row.ErrorClass != string(ClassPollingSuspended) {
+			t.units[row.IDUnit] = unitStatus{status: row.Status}
+		}
+	}
+	for _, tr := range transitions {
+		t.units[tr.Unit] = unitStatus{status: tr.To, since: tr.ChangedAt}
+	}
+	return nil
+}
+
+// Observe records a unit's new status and returns the transition it makes, or
+// nil when the status is unchanged or the unit was never seen before
+func (t *TransitionTracker) Observe(alias, status string, now time.Time) *Transition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev, ok := t.units[alias]
+	if ok && prev.status == status {
+		return nil
+	}
+	t.units[alias] = unitStatus{status: status, since: now.Unix()}
+	if !ok {
+		return nil
+	}
+
+	tr := &Transition{Unit: alias, From: prev.status, To: status, ChangedAt: now.Unix(), Duration: -1}
+	if prev.since > 0 {
+		tr.Duration = now.Unix() - prev.since
+	}
+	return tr
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// statusStore serves fixed latest statuses and transitions, the rest of Store is unused
+type statusStore struct {
+	Store
+	latest      []StatusRow
+	transitions []Transition
+}
+
+func (s *statusStore) LatestStatus(ctx context.Context) ([]StatusRow, error) {
+	return s.latest, nil
+}
+
+func (s *statusStore) LatestTransitions(ctx context.Context) ([]Transition, error) {
+	return s.transitions, nil
+}
+
+func newTestTracker(t *testing.T, store *statusStore) *TransitionTracker {
+	t.Helper()
+	tracker := newTransitionTracker()
+	err := tracker.Load(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tracker
+}
+
+func TestTransitionTracker(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	type observation struct {
+		after  time.Duration // Since start
+		status string
+		want   *Transition
+	}
+	tests := []struct {
+		name         string
+		store        *statusStore
+		observations []observation
+	}{
+		{
+			name:  "first poll of a new unit is no transition",
+			store: &statusStore{},
+			observations: []observation{
+				{0, "ESTABLISHED", nil},
+				{time.Minute, "ESTABLISHED", nil},
+				{2 * time.Minute, "SYN_SENT", &Transition{Unit: "U1", From: "ESTABLISHED", To: "SYN_SENT", ChangedAt: start.Add(2 * time.Minute).Unix(), Duration: 120}},
+				{5 * time.Minute, "ESTABLISHED", &Transition{Unit: "U1", From: "SYN_SENT", To: "ESTABLISHED", ChangedAt: start.Add(5 * time.Minute).Unix(), Duration: 180}},
+			},
+		},
+		{
+			name:  "status restored from the latest row has no known start",
+			store: &statusStore{latest: []StatusRow{{IDUnit: "U1", Status: "ESTABLISHED"}}},
+			observations: []observation{
+				{0, "ESTABLISHED", nil},
+				{time.Minute, "TIME_WAIT", &Transition{Unit: "U1", From: "ESTABLISHED", To: "TIME_WAIT", ChangedAt: start.Add(time.Minute).Unix(), Duration: -1}},
+			},
+		},
+		{
+			name: "latest transition wins over the latest row",
+			store: &statusStore{
+				latest:      []StatusRow{{IDUnit: "U1", Status: "ESTABLISHED"}},
+				transitions: []Transition{{Unit: "U1", From: "ESTABLISHED", To: "SYN_SENT", ChangedAt: start.Add(-time.Hour).Unix()}},
+			},
+			observations: []observation{
+				{0, "ESTABLISHED", &Transition{Unit: "U1", From: "SYN_SENT", To: "ESTABLISHED", ChangedAt: start.Unix(), Duration: 3600}},
+			},
+		},
+		{
+			name:  "suspended rows are not a status",
+			store: &statusStore{latest: []StatusRow{{IDUnit: "U1", Status: "Failed to Connect", ErrorClass: string(ClassPollingSuspended)}}},
+			observations: []observation{
+				{0, "ESTABLISHED", nil},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newTestTracker(t, tt.store)
+			for i, o := range tt.observations {
+				got := tracker.Observe("U1", o.status, start.Add(o.after))
+				if !reflect.DeepEqual(got, o.want) {
+					t.Errorf("observation %d: Observe(%s) = %+v, want %+v", i+1, o.status, got, o.want)
+				}
+			}
+		})
+	}
+}
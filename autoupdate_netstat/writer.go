@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+const (
+	defaultFlushInterval = 5 * time.Second
+	flushTimeout         = 30 * time.Second // Max time one batch may take to write
+)
+
+// ResultWriter buffers poll results from the worker goroutines and writes them
+// with multi-row INSERTs, so a sweep costs a few transactions instead of one
+// connection per unit. A batch is flushed when it is full, when the flush
+// interval passes, or on Close. Batches the database refuses go to the
+// spool, and so does everything after them until the spool is replayed.
+type ResultWriter struct {
+	store      Store
+	spool      *Spool        // nil when spooling is disabled
+	changes    *ChangeFilter // Told about results that never reach the database
+	ready      bool          // Schema is up to date, false while the database has been down since startup
+	nextReplay time.Time     // Replays wait until then after a failure
+	size       int
+	interval   time.Duration
+	results    chan PollResult
+	done       chan struct{}
+	closeCtx   context.Context // Bounds the final flush, set by Close
+}
+
+func newResultWriter(store Store, spool *Spool, changes *ChangeFilter, ready bool, size int, interval time.Duration) *ResultWriter {
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+
+	w := &ResultWriter{
+		store:    store,
+		spool:    spool,
+		changes:  changes,
+		ready:    ready,
+		size:     size,
+		interval: interval,
+		results:  make(chan PollResult, size),
+		done:     make(chan struct{}),
+	}
+	if !ready {
+		// main just found the database down
+		w.nextReplay = time.Now().Add(spoolRetryInterval)
+	}
+	go w.run()
+	return w
+}
+
+// Write queues a result. It blocks while a full batch is being flushed,
+// which keeps memory bounded if the database is slow, unless ctx ends first.
+func (w *ResultWriter) Write(ctx context.Context, result PollResult) {
+	if result.PollID == "" {
+		result.PollID = newPollID()
+	}
+	if result.PolledAt.IsZero() {
+		result.PolledAt = time.Now()
+	}
+	select {
+	case w.results <- result:
+	case <-ctx.Done():
+		log.Printf("Dropped result for %s (%s), shutting down\n", result.Server.Alias, result.Server.IP.String)
+		w.changes.Forget([]PollResult{result})
+	}
+}
+
+// Close flushes whatever is buffered within ctx and stops the writer. No
+// Write may happen after Close.
+func (w *ResultWriter) Close(ctx context.Context) {
+	w.closeCtx = ctx
+	close(w.results)
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		log.Println("Timed out flushing buffered results")
+	}
+}
+
+func (w *ResultWriter) run() {
+	defer close(w.done)
+
+	// Catch up on what earlier runs spooled before writing anything new
+	w.replay()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make([]PollResult, 0, w.size)
+	for {
+		select {
+		case result, ok := <-w.results:
+			if !ok {
+				w.flush(w.closeCtx, batch)
+				if w.spool != nil {
+					w.spool.Close()
+				}
+				return
+			}
+			batch = append(batch, result)
+			if len(batch) >= w.size {
+				w.flushWithTimeout(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.flushWithTimeout(batch)
+			batch = batch[:0]
+			w.replay()
+		}
+	}
+}
+
+// flushWithTimeout bounds a routine flush so a hung database cannot stall the writer forever
+func (w *ResultWriter) flushWithTimeout(batch []PollResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	w.flush(ctx, batch)
+}
+
+func (w *ResultWriter) flush(ctx context.Context, batch []PollResult) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// Results queue behind the spooled ones so they reach the database in order
+	if w.spool != nil && (!w.ready || w.spool.Pending()) {
+		w.toSpool(batch)
+		return
+	}
+
+	err := w.insert(ctx, batch)
+	if err != nil {
+		if w.spool != nil {
+			log.Printf("Failed to insert %d results into database, spooling them: %v\n", len(batch), err)
+			w.toSpool(batch)
+			w.nextReplay = time.Now().Add(spoolRetryInterval)
+			return
+		}
+		for _, r := range batch {
+			log.Printf("Failed to insert data for %s (%s) into database: %v\n", r.Server.Alias, r.Server.IP.String, err)
+		}
+		w.changes.Forget(batch)
+		return
+	}
+	log.Printf("Data inserted successfully for %d units into database\n", len(batch))
+}
+
+// insert writes one batch to the store
+func (w *ResultWriter) insert(ctx context.Context, batch []PollResult) error {
+	start := time.Now()
+	err := w.store.InsertResults(ctx, batch)
+	dbInsertDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		dbInsertErrors.Inc()
+	}
+	return err
+}
+
+func (w *ResultWriter) toSpool(batch []PollResult) {
+	dropped, err := w.spool.Append(batch)
+	if err != nil {
+		for _, r := range batch {
+			log.Printf("Failed to spool data for %s (%s): %v\n", r.Server.Alias, r.Server.IP.String, err)
+		}
+		w.changes.Forget(batch)
+	}
+	if dropped > 0 {
+		// Which units lost a row is not known, record every unit in full again
+		w.changes.Reset()
+	}
+}
+
+// replay writes the spooled results back to the database, oldest segment
+// first, after bringing the schema up to date if the database was down at
+// startup. After a failure the database is left alone for spoolRetryInterval.
+func (w *ResultWriter) replay() {
+	if w.spool == nil || (w.ready && !w.spool.Pending()) || time.Now().Before(w.nextReplay) {
+		return
+	}
+
+	if !w.ready {
+		err := w.store.Init()
+		if err != nil {
+			log.Printf("Database still unavailable, results stay spooled: %v\n", err)
+			w.nextReplay = time.Now().Add(spoolRetryInterval)
+			return
+		}
+		log.Println("Database is back, replaying spooled results")
+		w.ready = true
+	}
+
+	total := 0
+	for w.spool.Pending() {
+		n, err := w.spool.Replay(w.size, func(batch []PollResult) error {
+			ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			defer cancel()
+			return w.insert(ctx, batch)
+		})
+		total += n
+		if err != nil {
+			log.Printf("Failed to replay spooled results: %v\n", err)
+			w.nextReplay = time.Now().Add(spoolRetryInterval)
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("Replayed %d spooled results into database\n", total)
+	}
+}
+
+// newPollID returns a random id that ties a display_status row to its
+// display_connections and display_targets rows
+func newPollID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// archiveFile appends rows removed by retention to a gzipped JSONL or CSV
+// file, one file per table and run
+type archiveFile struct {
+	file    *os.File
+	gz      *gzip.Writer
+	csv     *csv.Writer
+	format  string
+	columns []string
+}
+
+func createArchiveFile(dir, table, format string, columns []string) (*archiveFile, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive dir: %v", err)
+	}
+	name := fmt.Sprintf("%s-%s.%s.gz", table, time.Now().Format("20060102-150405"), format)
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive file: %v", err)
+	}
+
+	a := &archiveFile{file: file, gz: gzip.NewWriter(file), format: format, columns: columns}
+	if format == "csv" {
+		a.csv = csv.NewWriter(a.gz)
+		err = a.csv.Write(columns)
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Write appends rows and flushes them through to the file, so rows are on
+// disk before the caller deletes them from the database
+func (a *archiveFile) Write(rows [][]interface{}) error {
+	for _, row := range rows {
+		var err error
+		if a.format == "csv" {
+			record := make([]string, len(row))
+			for i, v := range row {
+				if v != nil {
+					record[i] = fmt.Sprint(v)
+				}
+			}
+			err = a.csv.Write(record)
+		} else {
+			obj := make(map[string]interface{}, len(row))
+			for i, v := range row {
+				obj[a.columns[i]] = v
+			}
+			var line []byte
+			line, err = json.Marshal(obj)
+			if err == nil {
+				_, err = a.gz.Write(append(line, '\n'))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write archive: %v", err)
+		}
+	}
+
+	if a.csv != nil {
+		a.csv.Flush()
+		err := a.csv.Error()
+		if err != nil {
+			return fmt.Errorf("failed to write archive: %v", err)
+		}
+	}
+	err := a.gz.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+	return a.file.Sync()
+}
+
+func (a *archiveFile) Close() error {
+	err := a.gz.Close()
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
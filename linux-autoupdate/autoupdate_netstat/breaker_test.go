@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// breakerStore keeps breaker states in memory, the rest of Store is unused
+type breakerStore struct {
+	Store
+	states []BreakerState
+	saved  []BreakerState
+}
+
+func (s *breakerStore) LoadBreakers(ctx context.Context) ([]BreakerState, error) {
+	return s.states, nil
+}
+
+func (s *breakerStore) SaveBreaker(ctx context.Context, st BreakerState) error {
+	s.saved = append(s.saved, st)
+	return nil
+}
+
+func newTestBreakers(t *testing.T, store *breakerStore) *Breakers {
+	t.Helper()
+	b := newBreakers(BreakerConfig{Threshold: 3, ProbeInterval: Duration(10 * time.Minute)}, store)
+	err := b.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBreakerRecord(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	timeout := ClassTCPTimeout
+
+	type allowCheck struct {
+		after time.Duration // Since the last recorded poll
+		want  bool
+	}
+	tests := []struct {
+		name         string
+		classes      []ErrorClass // Poll outcomes, one second apart
+		wantState    string
+		wantFailures int
+		allow        []allowCheck // Checked in order
+	}{
+		{
+			name:         "below the threshold",
+			classes:      []ErrorClass{timeout, timeout},
+			wantState:    breakerClosed,
+			wantFailures: 2,
+			allow:        []allowCheck{{0, true}},
+		},
+		{
+			name:         "threshold suspends until the probe is due",
+			classes:      []ErrorClass{timeout, ClassConnectionRefused, ClassDNSFailure},
+			wantState:    breakerOpen,
+			wantFailures: 3,
+			allow: []allowCheck{
+				{time.Minute, false},
+				{10 * time.Minute, true},
+				{10 * time.Minute, false}, // One probe per interval
+				{20 * time.Minute, true},
+			},
+		},
+		{
+			name:         "failed probe keeps it suspended",
+			classes:      []ErrorClass{timeout, timeout, timeout, timeout},
+			wantState:    breakerOpen,
+			wantFailures: 4,
+			allow:        []allowCheck{{5 * time.Minute, false}, {10 * time.Minute, true}},
+		},
+		{
+			name:         "gateway failure says nothing about the unit",
+			classes:      []ErrorClass{timeout, timeout, ClassGatewayFailure, timeout},
+			wantState:    breakerOpen,
+			wantFailures: 3,
+		},
+		{
+			name:         "invalid IP and missing credentials say nothing about the unit",
+			classes:      []ErrorClass{timeout, timeout, ClassInvalidIP, ClassNoCredentials},
+			wantState:    breakerClosed,
+			wantFailures: 2,
+		},
+		{
+			name:         "suspended unit stays suspended without contact",
+			classes:      []ErrorClass{timeout, timeout, timeout, ClassGatewayFailure, ClassNoCredentials},
+			wantState:    breakerOpen,
+			wantFailures: 3,
+			allow:        []allowCheck{{time.Minute, false}},
+		},
+		{
+			name:         "rejected login keeps the count",
+			classes:      []ErrorClass{timeout, timeout, ClassAuthRejected, ClassHostKeyMismatch, timeout},
+			wantState:    breakerOpen,
+			wantFailures: 3,
+		},
+		{
+			name:         "an answer after the handshake resets the count",
+			classes:      []ErrorClass{timeout, timeout, ClassCommandFailed, timeout, timeout},
+			wantState:    breakerClosed,
+			wantFailures: 2,
+		},
+		{
+			name:         "successful probe resumes polling",
+			classes:      []ErrorClass{timeout, timeout, timeout, ClassNone},
+			wantState:    breakerClosed,
+			wantFailures: 0,
+			allow:        []allowCheck{{0, true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &breakerStore{}
+			b := newTestBreakers(t, store)
+
+			now := start
+			for _, class := range tt.classes {
+				now = now.Add(time.Second)
+				b.Record(context.Background(), "U1", class, now)
+			}
+
+			st := b.units["U1"]
+			if st.State != tt.wantState || st.Failures != tt.wantFailures {
+				t.Errorf("breaker = %s with %d failures, want %s with %d", st.State, st.Failures, tt.wantState, tt.wantFailures)
+			}
+			if len(store.saved) == 0 || store.saved[len(store.saved)-1] != *st {
+				t.Errorf("last saved state %+v, want %+v", store.saved, *st)
+			}
+			for _, check := range tt.allow {
+				got := b.Allow("U1", now.Add(check.after))
+				if got != check.want {
+					t.Errorf("Allow() %v after the last poll = %v, want %v", check.after, got, check.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerRestore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	store := &breakerStore{states: []BreakerState{
+		{Unit: "waiting", State: breakerOpen, Failures: 5, NextProbe: now.Add(time.Minute).Unix()},
+		{Unit: "due", State: breakerOpen, Failures: 5, NextProbe: now.Add(-time.Minute).Unix()},
+		{Unit: "closed", State: breakerClosed, Failures: 2},
+	}}
+	b := newTestBreakers(t, store)
+
+	tests := []struct {
+		unit string
+		want bool
+	}{
+		{"waiting", false},
+		{"due", true},
+		{"closed", true},
+		{"unknown", true},
+	}
+	for _, tt := range tests {
+		got := b.Allow(tt.unit, now)
+		if got != tt.want {
+			t.Errorf("Allow(%s) = %v, want %v", tt.unit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Collector self-monitoring, exposed on /metrics in daemon mode
+var (
+	cycleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "netstat_cycle_duration_seconds",
+		Help:    "Time taken by one polling cycle.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"cycle"})
+
+	unitsPolled = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "netstat_units_polled_total",
+		Help: "Units polled, whatever the outcome.",
+	})
+
+	unitsSucceeded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "netstat_units_succeeded_total",
+		Help: "Polls that returned a netstat result.",
+	})
+
+	unitsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "netstat_units_failed_total",
+		Help: "Polls that failed, by error class.",
+	}, []string{"class"})
+
+	sshDialDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "netstat_ssh_dial_duration_seconds",
+		Help:    "Time to dial and authenticate an SSH connection.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	sshCommandDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "netstat_ssh_command_duration_seconds",
+		Help:    "Time to run the netstat command on a unit.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	pollRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "netstat_poll_retries_total",
+		Help: "Poll attempts that were retried.",
+	})
+
+	dbInsertDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "netstat_db_insert_duration_seconds",
+		Help:    "Time to write one batch of results.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	dbInsertErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "netstat_db_insert_errors_total",
+		Help: "Batches that failed to write.",
+	})
+
+	unitLinkState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netstat_unit_link_state",
+		Help: "Current link state of each unit, 1 for the state it is in.",
+	}, []string{"unit", "state"})
+
+	reachLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "netstat_reach_latency_seconds",
+		Help:    "TCP connect time from the collector to units, by port.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"port"})
+
+	unitConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "netstat_unit_connections",
+		Help: "Connections from each unit to its primary target, by TCP state.",
+	}, []string{"unit", "state"})
+
+	spoolBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "netstat_spool_bytes",
+		Help: "Size of the results spooled on disk while the database is unreachable.",
+	})
+
+	spoolReplayed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "netstat_spool_replayed_total",
+		Help: "Spooled results written to the database once it was back.",
+	})
+
+	spoolDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "netstat_spool_dropped_total",
+		Help: "Spooled results lost because the spool reached its size cap.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		cycleDuration,
+		unitsPolled,
+		unitsSucceeded,
+		unitsFailed,
+		sshDialDuration,
+		sshCommandDuration,
+		pollRetries,
+		dbInsertDuration,
+		dbInsertErrors,
+		unitLinkState,
+		unitConnections,
+		reachLatency,
+		spoolBytes,
+		spoolReplayed,
+		spoolDropped,
+	)
+}
+
+// unitStates remembers each unit's last state label so it can be removed on change
+var unitStates = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// recordPollMetrics counts a finished poll and updates the unit's state gauge
+func recordPollMetrics(result PollResult) {
+	unitsPolled.Inc()
+	if result.ErrorClass == ClassNone {
+		unitsSucceeded.Inc()
+	} else {
+		unitsFailed.WithLabelValues(string(result.ErrorClass)).Inc()
+	}
+
+	state := result.Status
+	if state == "" {
+		state = "Netstat not detect Master"
+	}
+
+	unitStates.Lock()
+	defer unitStates.Unlock()
+	if prev, ok := unitStates.m[result.Server.Alias]; ok && prev != state {
+		unitLinkState.DeleteLabelValues(result.Server.Alias, prev)
+	}
+	unitStates.m[result.Server.Alias] = state
+	unitLinkState.WithLabelValues(result.Server.Alias, state).Set(1)
+
+	// Failed polls keep the last known counts rather than reporting zero
+	if result.ErrorClass == ClassNone {
+		unitConnections.DeletePartialMatch(prometheus.Labels{"unit": result.Server.Alias})
+		for st, n := range result.StateCounts {
+			unitConnections.WithLabelValues(result.Server.Alias, st).Set(float64(n))
+		}
+	}
+}
+
+// serveMetrics exposes /metrics on addr in the background
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		log.Printf("Serving metrics on %s/metrics", addr)
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,90 @@
+-- Schema as it stood before versioned migrations. Every statement is
+-- IF NOT EXISTS so databases created by older collectors are adopted as is.
+
+CREATE TABLE IF NOT EXISTS display_status (
+    id SERIAL PRIMARY KEY,
+    date_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    poll_id VARCHAR(32),
+    id_unit VARCHAR(255),
+    ip_unit VARCHAR(255),
+    foreign_address VARCHAR(255),
+    foreign_name VARCHAR(255),
+    status VARCHAR(255),
+    state_counts TEXT,
+    error_class VARCHAR(64),
+    error_detail VARCHAR(1024),
+    attempts INT,
+    attempt_history TEXT,
+    ssh_reachable BOOLEAN,
+    ssh_latency_ms DOUBLE PRECISION,
+    reachability TEXT
+);
+
+CREATE TABLE IF NOT EXISTS display_connections (
+    id SERIAL PRIMARY KEY,
+    poll_id VARCHAR(32),
+    date_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    id_unit VARCHAR(255),
+    proto VARCHAR(16),
+    recv_q INT,
+    send_q INT,
+    local_address VARCHAR(255),
+    local_port VARCHAR(64),
+    foreign_address VARCHAR(255),
+    foreign_name VARCHAR(255),
+    foreign_port VARCHAR(64),
+    state VARCHAR(32)
+);
+
+CREATE TABLE IF NOT EXISTS display_targets (
+    id SERIAL PRIMARY KEY,
+    poll_id VARCHAR(32),
+    date_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    id_unit VARCHAR(255),
+    target VARCHAR(255),
+    foreign_address VARCHAR(255),
+    foreign_name VARCHAR(255),
+    status VARCHAR(255),
+    state_counts TEXT
+);
+
+CREATE TABLE IF NOT EXISTS unit_breakers (
+    id_unit VARCHAR(255) PRIMARY KEY,
+    state VARCHAR(16),
+    failures INT,
+    opened_at BIGINT,
+    next_probe BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS status_transitions (
+    id SERIAL PRIMARY KEY,
+    poll_id VARCHAR(32),
+    id_unit VARCHAR(255),
+    from_status VARCHAR(255),
+    to_status VARCHAR(255),
+    changed_at BIGINT,
+    duration_seconds BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS idx_status_transitions_unit ON status_transitions (id_unit, id);
+
+CREATE TABLE IF NOT EXISTS unit_latest (
+    id_unit VARCHAR(255) PRIMARY KEY,
+    poll_id VARCHAR(32),
+    ip_unit VARCHAR(255),
+    status VARCHAR(255),
+    foreign_address VARCHAR(255),
+    error_class VARCHAR(64),
+    last_seen TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS status_hourly (
+    id SERIAL PRIMARY KEY,
+    id_unit VARCHAR(255),
+    hour_start TIMESTAMP,
+    status VARCHAR(255),
+    minutes DOUBLE PRECISION,
+    drops INT
+);
+
+CREATE INDEX IF NOT EXISTS idx_status_hourly_unit ON status_hourly (id_unit, hour_start);
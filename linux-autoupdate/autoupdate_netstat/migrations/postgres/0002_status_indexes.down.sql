@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_display_status_status_time;
+DROP INDEX IF EXISTS idx_display_status_unit_time;
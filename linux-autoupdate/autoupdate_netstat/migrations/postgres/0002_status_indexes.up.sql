@@ -0,0 +1,4 @@
+-- The APIs rank display_status per unit by date_time and look up ESTABLISHED
+-- and SYN_SENT rows by time, both full scans without these.
+CREATE INDEX IF NOT EXISTS idx_display_status_unit_time ON display_status (id_unit, date_time);
+CREATE INDEX IF NOT EXISTS idx_display_status_status_time ON display_status (status, date_time);
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Connection represents a single socket row parsed from netstat output
+type Connection struct {
+	Proto          string `json:"proto"`
+	RecvQ          int    `json:"recv_q"`
+	SendQ          int    `json:"send_q"`
+	LocalAddress   string `json:"local_address"`
+	LocalPort      string `json:"local_port"`
+	ForeignAddress string `json:"foreign_address"`
+	ForeignPort    string `json:"foreign_port"`
+	ForeignName    string `json:"foreign_name,omitempty"` // Logical name from the names config
+	State          string `json:"state"`
+}
+
+// Foreign returns the foreign endpoint in the same host:port form netstat prints
+func (c Connection) Foreign() string {
+	return c.ForeignAddress + ":" + c.ForeignPort
+}
+
+// errUnrecognizedOutput means the command output has no netstat table at all
+var errUnrecognizedOutput = errors.New("output is not a netstat connection table")
+
+// netstatColumns says where each field sits in a netstat row. GNU netstat and
+// busybox netstat agree on the usual layout, but some busybox builds drop the
+// queue columns or add PID/Program name, so the header is read when present.
+type netstatColumns struct {
+	proto, recvQ, sendQ, local, foreign, state int
+}
+
+var defaultNetstatColumns = netstatColumns{proto: 0, recvQ: 1, sendQ: 2, local: 3, foreign: 4, state: 5}
+
+// parseNetstatHeader maps the header row to column positions. Two-word
+// headers such as "Local Address" count as one column.
+func parseNetstatHeader(parts []string) netstatColumns {
+	cols := netstatColumns{proto: -1, recvQ: -1, sendQ: -1, local: -1, foreign: -1, state: -1}
+	col := 0
+	for i := 0; i < len(parts); i++ {
+		switch parts[i] {
+		case "Proto":
+			cols.proto = col
+		case "Recv-Q":
+			cols.recvQ = col
+		case "Send-Q":
+			cols.sendQ = col
+		case "Local":
+			cols.local = col
+			i++ // "Address"
+		case "Foreign":
+			cols.foreign = col
+			i++ // "Address"
+		case "State":
+			cols.state = col
+		case "PID/Program":
+			i++ // "name"
+		}
+		col++
+	}
+	if cols.proto < 0 || cols.local < 0 || cols.foreign < 0 {
+		return defaultNetstatColumns
+	}
+	return cols
+}
+
+// parseNetstat turns the "Active Internet connections" part of netstat output
+// into typed records. Header lines and unix domain sockets are skipped.
+func parseNetstat(output []byte) ([]Connection, error) {
+	var connections []Connection
+	sawHeader := false
+	cols := defaultNetstatColumns
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text()) // Split by any whitespace
+		if len(parts) > 0 && parts[0] == "Proto" {
+			sawHeader = true
+			cols = parseNetstatHeader(parts)
+			continue
+		}
+		if len(parts) <= cols.foreign || !isInternetProto(parts[cols.proto]) {
+			continue
+		}
+
+		conn := Connection{Proto: parts[cols.proto]}
+		var err error
+		if cols.recvQ >= 0 {
+			conn.RecvQ, err = strconv.Atoi(parts[cols.recvQ])
+			if err != nil {
+				continue
+			}
+		}
+		if cols.sendQ >= 0 {
+			conn.SendQ, err = strconv.Atoi(parts[cols.sendQ])
+			if err != nil {
+				continue
+			}
+		}
+		conn.LocalAddress, conn.LocalPort = splitAddress(parts[cols.local])
+		conn.ForeignAddress, conn.ForeignPort = splitAddress(parts[cols.foreign])
+		if cols.state >= 0 && len(parts) > cols.state && !strings.Contains(parts[cols.state], "/") {
+			conn.State = parts[cols.state]
+		}
+
+		connections = append(connections, conn)
+	}
+
+	// An idle unit still prints the header, no header and no rows is garbage
+	if !sawHeader && len(connections) == 0 {
+		return nil, errUnrecognizedOutput
+	}
+	return connections, nil
+}
+
+func isInternetProto(proto string) bool {
+	return strings.HasPrefix(proto, "tcp") || strings.HasPrefix(proto, "udp") || strings.HasPrefix(proto, "raw")
+}
+
+// splitAddress splits a netstat address column on its last colon, so IPv6
+// addresses such as "::1:22" keep their inner colons.
+func splitAddress(address string) (string, string) {
+	i := strings.LastIndex(address, ":")
+	if i < 0 {
+		return address, ""
+	}
+	return address[:i], address[i+1:]
+}
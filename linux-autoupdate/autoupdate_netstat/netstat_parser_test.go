@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNetstat(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Connection
+		err    error
+	}{
+		{
+			name: "gnu netstat",
+			output: `Active Internet connections (servers and established)
+Proto Recv-Q Send-Q Local Address           Foreign Address         State
+tcp        0      0 0.0.0.0:22              0.0.0.0:*               LISTEN
+tcp        0     36 10.0.0.5:22             10.0.0.1:51234          ESTABLISHED
+udp        0      0 0.0.0.0:68              0.0.0.0:*
+Active UNIX domain sockets (servers and established)
+Proto RefCnt Flags       Type       State         I-Node   Path
+unix  2      [ ACC ]     STREAM     LISTENING     12345    /run/dbus/system_bus_socket
+`,
+			want: []Connection{
+				{Proto: "tcp", LocalAddress: "0.0.0.0", LocalPort: "22", ForeignAddress: "0.0.0.0", ForeignPort: "*", State: "LISTEN"},
+				{Proto: "tcp", SendQ: 36, LocalAddress: "10.0.0.5", LocalPort: "22", ForeignAddress: "10.0.0.1", ForeignPort: "51234", State: "ESTABLISHED"},
+				{Proto: "udp", LocalAddress: "0.0.0.0", LocalPort: "68", ForeignAddress: "0.0.0.0", ForeignPort: "*"},
+			},
+		},
+		{
+			name: "ipv6 addresses keep their inner colons",
+			output: `Proto Recv-Q Send-Q Local Address           Foreign Address         State
+tcp6       0      0 ::1:631                 ::1:40000               ESTABLISHED
+`,
+			want: []Connection{
+				{Proto: "tcp6", LocalAddress: "::1", LocalPort: "631", ForeignAddress: "::1", ForeignPort: "40000", State: "ESTABLISHED"},
+			},
+		},
+		{
+			name: "busybox with program column",
+			output: `Proto Recv-Q Send-Q Local Address           Foreign Address         State       PID/Program name
+tcp        0      0 192.168.1.2:502         192.168.1.9:4410        ESTABLISHED 812/modbusd
+udp        0      0 0.0.0.0:161             0.0.0.0:*                           640/snmpd
+`,
+			want: []Connection{
+				{Proto: "tcp", LocalAddress: "192.168.1.2", LocalPort: "502", ForeignAddress: "192.168.1.9", ForeignPort: "4410", State: "ESTABLISHED"},
+				{Proto: "udp", LocalAddress: "0.0.0.0", LocalPort: "161", ForeignAddress: "0.0.0.0", ForeignPort: "*"},
+			},
+		},
+		{
+			name: "busybox without queue columns",
+			output: `Proto Local Address           Foreign Address         State
+tcp   10.1.1.1:22             10.1.1.2:60000          TIME_WAIT
+`,
+			want: []Connection{
+				{Proto: "tcp", LocalAddress: "10.1.1.1", LocalPort: "22", ForeignAddress: "10.1.1.2", ForeignPort: "60000", State: "TIME_WAIT"},
+			},
+		},
+		{
+			name: "rows without a header use the default layout",
+			output: `tcp        0      0 10.0.0.5:22             10.0.0.1:51234          SYN_SENT
+`,
+			want: []Connection{
+				{Proto: "tcp", LocalAddress: "10.0.0.5", LocalPort: "22", ForeignAddress: "10.0.0.1", ForeignPort: "51234", State: "SYN_SENT"},
+			},
+		},
+		{
+			name: "rows with bad queue counts are skipped",
+			output: `Proto Recv-Q Send-Q Local Address           Foreign Address         State
+tcp        x      0 10.0.0.5:22             10.0.0.1:51234          ESTABLISHED
+`,
+		},
+		{
+			name: "idle unit prints only the header",
+			output: `Active Internet connections (servers and established)
+Proto Recv-Q Send-Q Local Address           Foreign Address         State
+`,
+		},
+		{
+			name:   "not a netstat table",
+			output: "sh: netstat: not found\n",
+			err:    errUnrecognizedOutput,
+		},
+		{
+			name: "empty output",
+			err:  errUnrecognizedOutput,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNetstat([]byte(tt.output))
+			if err != tt.err {
+				t.Fatalf("parseNetstat() error = %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseNetstat() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		host    string
+		port    string
+	}{
+		{"10.0.0.1:22", "10.0.0.1", "22"},
+		{"0.0.0.0:*", "0.0.0.0", "*"},
+		{"::1:22", "::1", "22"},
+		{"fe80::1%eth0:443", "fe80::1%eth0", "443"},
+		{"localhost", "localhost", ""},
+	}
+
+	for _, tt := range tests {
+		host, port := splitAddress(tt.address)
+		if host != tt.host || port != tt.port {
+			t.Errorf("splitAddress(%q) = %q, %q, want %q, %q", tt.address, host, port, tt.host, tt.port)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ReachConfig controls the TCP connect probe the collector runs itself next
+// to each poll. Where netstat tells what the unit sees, this tells whether the
+// collector can reach the unit at all: a unit off the network fails every
+// port, a unit with broken SSH still answers on its other ports.
+type ReachConfig struct {
+	Disabled bool     `json:"disabled"`
+	Ports    []int    `json:"ports"`   // Probed besides the SSH port, e.g. 80 or 502
+	Timeout  Duration `json:"timeout"` // Per port connect timeout, default 3s
+}
+
+const (
+	sshPort             = 22
+	defaultReachTimeout = 3 * time.Second
+)
+
+// PortReach is the outcome of one TCP connect from the collector
+type PortReach struct {
+	Port      int     `json:"port"`
+	Open      bool    `json:"open"`
+	LatencyMs float64 `json:"latency_ms,omitempty"` // Connect time when open
+	Error     string  `json:"error,omitempty"`
+}
+
+// ReachProbe connects to the SSH port and the configured ports of a unit
+type ReachProbe struct {
+	ports   []int
+	timeout time.Duration
+}
+
+// newReachProbe returns nil when the probe is disabled
+func newReachProbe(cfg ReachConfig) *ReachProbe {
+	if cfg.Disabled {
+		return nil
+	}
+	p := &ReachProbe{ports: []int{sshPort}, timeout: time.Duration(cfg.Timeout)}
+	for _, port := range cfg.Ports {
+		if port != sshPort {
+			p.ports = append(p.ports, port)
+		}
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultReachTimeout
+	}
+	return p
+}
+
+// Run probes every port of ip at once and returns the results in port order,
+// the SSH port first
+func (p *ReachProbe) Run(ctx context.Context, ip string) []PortReach {
+	results := make([]PortReach, len(p.ports))
+	var wg sync.WaitGroup
+	for i, port := range p.ports {
+		wg.Add(1)
+		go func(i, port int) {
+			defer wg.Done()
+			results[i] = p.connect(ctx, ip, port)
+		}(i, port)
+	}
+	wg.Wait()
+	return results
+}
+
+func (p *ReachProbe) connect(ctx context.Context, ip string, port int) PortReach {
+	result := PortReach{Port: port}
+	dialer := &net.Dialer{Timeout: p.timeout}
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	elapsed := time.Since(start)
+	if err != nil {
+		result.Error = string(classifyDialError(err).Class)
+		return result
+	}
+	conn.Close()
+
+	reachLatency.WithLabelValues(strconv.Itoa(port)).Observe(elapsed.Seconds())
+	result.Open = true
+	result.LatencyMs = float64(elapsed.Microseconds()) / 1000
+	return result
+}
+
+// reach probes a unit from the collector. Units behind jump hosts are not
+// routable from here and are not probed, nor are units without a valid IP.
+func (c *Collector) reach(ctx context.Context, server Server) []PortReach {
+	if c.reachProbe == nil || !server.IP.Valid {
+		return nil
+	}
+	if len(c.jumpChain(server)) > 0 {
+		return nil
+	}
+	return c.reachProbe.Run(ctx, server.IP.String)
+}
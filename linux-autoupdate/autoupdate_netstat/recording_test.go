@@ -0,0 +1,134 @@
+package main
+
+import "testing"
+
+func recordedResult(alias, status, foreign string, class ErrorClass) PollResult {
+	return PollResult{Server: Server{Alias: alias}, Status: status, ForeignAddress: foreign, ErrorClass: class}
+}
+
+func TestNewChangeFilter(t *testing.T) {
+	tests := []struct {
+		mode       string
+		wantFilter bool
+		wantErr    bool
+	}{
+		{mode: "", wantFilter: false},
+		{mode: "all", wantFilter: false},
+		{mode: "changes", wantFilter: true},
+		{mode: "sometimes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		f, err := newChangeFilter(tt.mode)
+		if (err != nil) != tt.wantErr || (f != nil) != tt.wantFilter {
+			t.Errorf("newChangeFilter(%q) = %v, %v, want filter %v, error %v", tt.mode, f, err, tt.wantFilter, tt.wantErr)
+		}
+	}
+}
+
+func TestChangeFilterUnchanged(t *testing.T) {
+	established := recordedResult("U1", "ESTABLISHED", "10.0.0.1:502", ClassNone)
+
+	tests := []struct {
+		name    string
+		results []PollResult
+		want    []bool // Unchanged for each result in turn
+	}{
+		{
+			name:    "first poll is recorded",
+			results: []PollResult{established},
+			want:    []bool{false},
+		},
+		{
+			name:    "repeated state is a heartbeat",
+			results: []PollResult{established, established, established},
+			want:    []bool{false, true, true},
+		},
+		{
+			name: "status change is recorded",
+			results: []PollResult{
+				established,
+				recordedResult("U1", "SYN_SENT", "10.0.0.1:502", ClassNone),
+				established,
+			},
+			want: []bool{false, false, false},
+		},
+		{
+			name: "new foreign address is recorded",
+			results: []PollResult{
+				established,
+				recordedResult("U1", "ESTABLISHED", "10.0.0.2:502", ClassNone),
+			},
+			want: []bool{false, false},
+		},
+		{
+			name: "new error class is recorded",
+			results: []PollResult{
+				recordedResult("U1", "Failed to Connect", "", ClassTCPTimeout),
+				recordedResult("U1", "Failed to Connect", "", ClassConnectionRefused),
+				recordedResult("U1", "Failed to Connect", "", ClassConnectionRefused),
+			},
+			want: []bool{false, false, true},
+		},
+		{
+			name: "units are tracked apart",
+			results: []PollResult{
+				established,
+				recordedResult("U2", "ESTABLISHED", "10.0.0.1:502", ClassNone),
+				established,
+			},
+			want: []bool{false, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newChangeFilter(recordChanges)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, r := range tt.results {
+				got := f.Unchanged(r)
+				if got != tt.want[i] {
+					t.Errorf("result %d: Unchanged() = %v, want %v", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChangeFilterForget(t *testing.T) {
+	u1 := recordedResult("U1", "ESTABLISHED", "10.0.0.1:502", ClassNone)
+	u2 := recordedResult("U2", "ESTABLISHED", "10.0.0.2:502", ClassNone)
+
+	f, err := newChangeFilter(recordChanges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Unchanged(u1)
+	f.Unchanged(u2)
+
+	// A lost row means the next poll of that unit is written in full
+	f.Forget([]PollResult{u1})
+	if f.Unchanged(u1) {
+		t.Error("U1 after Forget: Unchanged() = true, want false")
+	}
+	if !f.Unchanged(u2) {
+		t.Error("U2 after Forget of U1: Unchanged() = false, want true")
+	}
+
+	f.Reset()
+	if f.Unchanged(u1) || f.Unchanged(u2) {
+		t.Error("Unchanged() = true after Reset, want false")
+	}
+}
+
+func TestChangeFilterAllMode(t *testing.T) {
+	var f *ChangeFilter
+	r := recordedResult("U1", "ESTABLISHED", "10.0.0.1:502", ClassNone)
+	if f.Unchanged(r) || f.Unchanged(r) {
+		t.Error("Unchanged() on the all-mode filter skipped a row")
+	}
+	f.Forget([]PollResult{r})
+	f.Reset()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// errOutputTooLarge is returned when a command prints more than the output cap
+var errOutputTooLarge = errors.New("command output exceeds the size limit")
+
+// runCommand runs cmd on the session and returns its combined output. If ctx
+// ends first, or the output grows past maxOutput bytes, the session is closed,
+// which unblocks the remote command and frees the worker slot.
+func runCommand(ctx context.Context, session *ssh.Session, cmd string, maxOutput int) ([]byte, error) {
+	out := &cappedBuffer{limit: maxOutput, overflow: make(chan struct{})}
+	session.Stdout = out
+	session.Stderr = out
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(cmd)
+	}()
+
+	select {
+	case err := <-done:
+		if out.exceeded() {
+			return nil, errOutputTooLarge
+		}
+		return out.Bytes(), err
+	case <-out.overflow:
+		session.Close()
+		return nil, errOutputTooLarge
+	case <-ctx.Done():
+		session.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// cappedBuffer collects stdout and stderr up to limit bytes. Past the limit
+// it keeps nothing more and closes overflow.
+type cappedBuffer struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	limit    int
+	full     bool
+	overflow chan struct{}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.full {
+		return 0, errOutputTooLarge
+	}
+	if b.buf.Len()+len(p) > b.limit {
+		b.full = true
+		close(b.overflow)
+		return 0, errOutputTooLarge
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) exceeded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.full
+}
+
+func (b *cappedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
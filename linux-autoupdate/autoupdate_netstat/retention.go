@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionConfig limits how long raw poll rows are kept. Older rows are
+// rolled up into status_hourly, then archived to disk or deleted.
+type RetentionConfig struct {
+	RawDays    int      `json:"raw_days"`    // Days of raw rows to keep, 0 disables retention
+	Archive    string   `json:"archive"`     // jsonl or csv to archive deleted rows, empty to only delete
+	ArchiveDir string   `json:"archive_dir"` // Where gzipped archive files are written
+	BatchSize  int      `json:"batch_size"`  // Rows deleted per statement, default 1000
+	BatchPause Duration `json:"batch_pause"` // Pause between batches, default 500ms
+	Interval   Duration `json:"interval"`    // Time between runs in daemon mode, default 1h
+	Blackouts  []string `json:"blackouts"`   // Local "HH:MM-HH:MM" windows with no retention work, e.g. shift change
+}
+
+const (
+	defaultRetentionBatch    = 1000
+	defaultRetentionPause    = 500 * time.Millisecond
+	defaultRetentionInterval = time.Hour
+	rollupChunk              = 24 * time.Hour // Raw rows read into memory at once by the rollup
+)
+
+// RetentionPolicy is a validated RetentionConfig
+type RetentionPolicy struct {
+	rawAge     time.Duration
+	archive    string
+	archiveDir string
+	batchSize  int
+	batchPause time.Duration
+	interval   time.Duration
+	blackouts  []blackout
+}
+
+// blackout is a daily window in minutes since local midnight. A window that
+// ends before it starts wraps past midnight.
+type blackout struct {
+	start, end int
+}
+
+// newRetentionPolicy returns nil when retention is disabled
+func newRetentionPolicy(cfg RetentionConfig) (*RetentionPolicy, error) {
+	if cfg.RawDays <= 0 {
+		return nil, nil
+	}
+
+	p := &RetentionPolicy{
+		rawAge:     time.Duration(cfg.RawDays) * 24 * time.Hour,
+		archive:    cfg.Archive,
+		archiveDir: cfg.ArchiveDir,
+		batchSize:  cfg.BatchSize,
+		batchPause: time.Duration(cfg.BatchPause),
+		interval:   time.Duration(cfg.Interval),
+	}
+	switch p.archive {
+	case "":
+	case "jsonl", "csv":
+		if p.archiveDir == "" {
+			return nil, fmt.Errorf("retention archive %s needs an archive_dir", p.archive)
+		}
+	default:
+		return nil, fmt.Errorf("unknown retention archive format %q, use jsonl or csv", p.archive)
+	}
+	if p.batchSize <= 0 {
+		p.batchSize = defaultRetentionBatch
+	}
+	if p.batchPause <= 0 {
+		p.batchPause = defaultRetentionPause
+	}
+	if p.interval <= 0 {
+		p.interval = defaultRetentionInterval
+	}
+
+	for _, window := range cfg.Blackouts {
+		b, err := parseBlackout(window)
+		if err != nil {
+			return nil, err
+		}
+		p.blackouts = append(p.blackouts, b)
+	}
+	return p, nil
+}
+
+func parseBlackout(window string) (blackout, error) {
+	parts := strings.SplitN(window, "-", 2)
+	if len(parts) != 2 {
+		return blackout{}, fmt.Errorf("invalid blackout %q, use HH:MM-HH:MM", window)
+	}
+	var minutes [2]int
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return blackout{}, fmt.Errorf("invalid blackout %q: %v", window, err)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	return blackout{start: minutes[0], end: minutes[1]}, nil
+}
+
+func (b blackout) contains(minute int) bool {
+	if b.start <= b.end {
+		return minute >= b.start && minute < b.end
+	}
+	return minute >= b.start || minute < b.end
+}
+
+// wait blocks while now is inside a blackout window. It returns false if ctx
+// ends first.
+func (p *RetentionPolicy) wait(ctx context.Context) bool {
+	logged := false
+	for {
+		now := time.Now()
+		minute := now.Hour()*60 + now.Minute()
+		inside := false
+		for _, b := range p.blackouts {
+			if b.contains(minute) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return ctx.Err() == nil
+		}
+		if !logged {
+			log.Println("Retention paused for a blackout window")
+			logged = true
+		}
+		select {
+		case <-time.After(time.Minute):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// pause sleeps between batches so other writers get the table. It returns
+// false if ctx ends first or a blackout window could not be waited out.
+func (p *RetentionPolicy) pause(ctx context.Context) bool {
+	select {
+	case <-time.After(p.batchPause):
+	case <-ctx.Done():
+		return false
+	}
+	return p.wait(ctx)
+}
+
+// runRetentionLoop runs retention now and then every interval until ctx ends.
+// Runs are skipped while spool holds results: they are inserted with their
+// poll time, which may fall in hours the rollup would otherwise close early.
+func runRetentionLoop(ctx context.Context, store Store, policy *RetentionPolicy, spool *Spool) {
+	for {
+		if spool != nil && spool.Pending() {
+			log.Println("Retention run skipped, spooled results are not replayed yet")
+		} else {
+			err := store.Retain(ctx, policy)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Retention run failed: %v", err)
+			}
+		}
+
+		select {
+		case <-time.After(policy.interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// statusPoint is a raw display_status row as seen by the rollup
+type statusPoint struct {
+	unit   string
+	status string
+	at     time.Time
+}
+
+// HourlyRow is one status_hourly row: the minutes a unit spent in a status
+// during an hour, and how many times it dropped from ESTABLISHED into it
+type HourlyRow struct {
+	Unit      string
+	HourStart time.Time
+	Status    string
+	Minutes   float64
+	Drops     int
+}
+
+type hourlyKey struct {
+	unit   string
+	hour   time.Time
+	status string
+}
+
+// rollupHours summarises points, sorted by unit then time, over [from, to).
+// A status holds from its row until the unit's next row, or to. anchors gives
+// each unit's status at from, taken from its last row before it; units
+// without an anchor are only counted from their first row.
+func rollupHours(anchors map[string]string, points []statusPoint, from, to time.Time) []HourlyRow {
+	sums := make(map[hourlyKey]*HourlyRow)
+	add := func(unit, status string, start, end time.Time) {
+		for start.Before(end) {
+			hour := start.Truncate(time.Hour)
+			next := hour.Add(time.Hour)
+			if next.After(end) {
+				next = end
+			}
+			key := hourlyKey{unit, hour, status}
+			row := sums[key]
+			if row == nil {
+				row = &HourlyRow{Unit: unit, HourStart: hour, Status: status}
+				sums[key] = row
+			}
+			row.Minutes += next.Sub(start).Minutes()
+			start = next
+		}
+	}
+	drop := func(unit, status string, at time.Time) {
+		key := hourlyKey{unit, at.Truncate(time.Hour), status}
+		row := sums[key]
+		if row == nil {
+			row = &HourlyRow{Unit: unit, HourStart: key.hour, Status: status}
+			sums[key] = row
+		}
+		row.Drops++
+	}
+
+	// Walk each unit's points, carrying its status from the anchor
+	seen := make(map[string]bool)
+	for i := 0; i < len(points); {
+		unit := points[i].unit
+		seen[unit] = true
+		status, ok := anchors[unit]
+		since := from
+		for ; i < len(points) && points[i].unit == unit; i++ {
+			p := points[i]
+			if ok {
+				add(unit, status, since, p.at)
+				if status == "ESTABLISHED" && p.status != "ESTABLISHED" {
+					drop(unit, p.status, p.at)
+				}
+			}
+			status, since, ok = p.status, p.at, true
+		}
+		add(unit, status, since, to)
+	}
+
+	// Units without rows in the window held their anchor status throughout
+	for unit, status := range anchors {
+		if !seen[unit] {
+			add(unit, status, from, to)
+		}
+	}
+
+	rows := make([]HourlyRow, 0, len(sums))
+	for _, row := range sums {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Unit != rows[j].Unit {
+			return rows[i].Unit < rows[j].Unit
+		}
+		if !rows[i].HourStart.Equal(rows[j].HourStart) {
+			return rows[i].HourStart.Before(rows[j].HourStart)
+		}
+		return rows[i].Status < rows[j].Status
+	})
+	return rows
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRollupHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 3, 1, hour, minute, 0, 0, time.UTC)
+	}
+	from, to := at(10, 0), at(12, 0)
+
+	tests := []struct {
+		name    string
+		anchors map[string]string
+		points  []statusPoint
+		want    []HourlyRow
+	}{
+		{
+			name: "nothing to roll up",
+			want: []HourlyRow{},
+		},
+		{
+			name:    "anchor only holds the whole window",
+			anchors: map[string]string{"A": "TIME_WAIT"},
+			want: []HourlyRow{
+				{Unit: "A", HourStart: at(10, 0), Status: "TIME_WAIT", Minutes: 60},
+				{Unit: "A", HourStart: at(11, 0), Status: "TIME_WAIT", Minutes: 60},
+			},
+		},
+		{
+			name: "unit without anchor counts from its first row",
+			points: []statusPoint{
+				{unit: "B", status: "ESTABLISHED", at: at(10, 20)},
+			},
+			want: []HourlyRow{
+				{Unit: "B", HourStart: at(10, 0), Status: "ESTABLISHED", Minutes: 40},
+				{Unit: "B", HourStart: at(11, 0), Status: "ESTABLISHED", Minutes: 60},
+			},
+		},
+		{
+			name:    "status spanning an hour boundary is split",
+			anchors: map[string]string{"A": "ESTABLISHED"},
+			points: []statusPoint{
+				{unit: "A", status: "SYN_SENT", at: at(10, 30)},
+				{unit: "A", status: "ESTABLISHED", at: at(11, 15)},
+			},
+			want: []HourlyRow{
+				{Unit: "A", HourStart: at(10, 0), Status: "ESTABLISHED", Minutes: 30},
+				{Unit: "A", HourStart: at(10, 0), Status: "SYN_SENT", Minutes: 30, Drops: 1},
+				{Unit: "A", HourStart: at(11, 0), Status: "ESTABLISHED", Minutes: 45},
+				{Unit: "A", HourStart: at(11, 0), Status: "SYN_SENT", Minutes: 15},
+			},
+		},
+		{
+			name:    "only leaving ESTABLISHED counts as a drop",
+			anchors: map[string]string{"A": "SYN_SENT"},
+			points: []statusPoint{
+				{unit: "A", status: "TIME_WAIT", at: at(10, 10)},
+				{unit: "A", status: "ESTABLISHED", at: at(10, 20)},
+				{unit: "A", status: "CLOSE_WAIT", at: at(10, 50)},
+				{unit: "A", status: "ESTABLISHED", at: at(11, 0)},
+				{unit: "A", status: "ESTABLISHED", at: at(11, 30)},
+			},
+			want: []HourlyRow{
+				{Unit: "A", HourStart: at(10, 0), Status: "CLOSE_WAIT", Minutes: 10, Drops: 1},
+				{Unit: "A", HourStart: at(10, 0), Status: "ESTABLISHED", Minutes: 30},
+				{Unit: "A", HourStart: at(10, 0), Status: "SYN_SENT", Minutes: 10},
+				{Unit: "A", HourStart: at(10, 0), Status: "TIME_WAIT", Minutes: 10},
+				{Unit: "A", HourStart: at(11, 0), Status: "ESTABLISHED", Minutes: 60},
+			},
+		},
+		{
+			name:    "units are rolled up independently",
+			anchors: map[string]string{"A": "ESTABLISHED", "C": "SYN_SENT"},
+			points: []statusPoint{
+				{unit: "A", status: "SYN_SENT", at: at(11, 0)},
+				{unit: "B", status: "ESTABLISHED", at: at(11, 30)},
+			},
+			want: []HourlyRow{
+				{Unit: "A", HourStart: at(10, 0), Status: "ESTABLISHED", Minutes: 60},
+				{Unit: "A", HourStart: at(11, 0), Status: "SYN_SENT", Minutes: 60, Drops: 1},
+				{Unit: "B", HourStart: at(11, 0), Status: "ESTABLISHED", Minutes: 30},
+				{Unit: "C", HourStart: at(10, 0), Status: "SYN_SENT", Minutes: 60},
+				{Unit: "C", HourStart: at(11, 0), Status: "SYN_SENT", Minutes: 60},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rollupHours(tt.anchors, tt.points, from, to)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rollupHours() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
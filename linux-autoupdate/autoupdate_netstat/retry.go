@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// RetryConfig controls how failed polls are retried
+type RetryConfig struct {
+	MaxAttempts    int         `json:"max_attempts"`    // Attempts per unit including the first, default maxRetries
+	InitialBackoff Duration    `json:"initial_backoff"` // Wait before the first retry
+	MaxBackoff     Duration    `json:"max_backoff"`     // Cap on the wait between attempts
+	Multiplier     float64     `json:"multiplier"`      // Growth of the wait after each retry
+	Jitter         *float64    `json:"jitter"`          // Fraction of the wait randomly added or removed, 0 to 1, default 0.2
+	Budget         int         `json:"budget"`          // Max retries across all units of one cycle, 0 is unlimited
+	Rules          []RetryRule `json:"rules"`           // Per error class attempt limits
+}
+
+// RetryRule overrides the number of attempts for one error class. A limit of
+// 1 means the class is never retried.
+type RetryRule struct {
+	Class       ErrorClass `json:"class"`
+	MaxAttempts int        `json:"max_attempts"`
+}
+
+const (
+	defaultInitialBackoff = 2 * time.Second
+	defaultMaxBackoff     = 30 * time.Second
+	defaultMultiplier     = 2.0
+	defaultJitter         = 0.2
+)
+
+// RetryPolicy is a parsed RetryConfig
+type RetryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+	jitter         float64
+	budget         int
+	rules          map[ErrorClass]int
+}
+
+func newRetryPolicy(cfg RetryConfig) (*RetryPolicy, error) {
+	p := &RetryPolicy{
+		maxAttempts:    cfg.MaxAttempts,
+		initialBackoff: time.Duration(cfg.InitialBackoff),
+		maxBackoff:     time.Duration(cfg.MaxBackoff),
+		multiplier:     cfg.Multiplier,
+		jitter:         defaultJitter,
+		budget:         cfg.Budget,
+		rules:          make(map[ErrorClass]int),
+	}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = maxRetries
+	}
+	if p.initialBackoff <= 0 {
+		p.initialBackoff = defaultInitialBackoff
+	}
+	if p.maxBackoff <= 0 {
+		p.maxBackoff = defaultMaxBackoff
+	}
+	if p.multiplier < 1 {
+		p.multiplier = defaultMultiplier
+	}
+	if cfg.Jitter != nil {
+		// Set explicitly, 0 turns jitter off
+		if *cfg.Jitter < 0 || *cfg.Jitter > 1 {
+			return nil, fmt.Errorf("retry jitter %v is not between 0 and 1", *cfg.Jitter)
+		}
+		p.jitter = *cfg.Jitter
+	}
+
+	for _, rule := range cfg.Rules {
+		if !rule.Class.Known() {
+			return nil, fmt.Errorf("unknown error class %q in retry rules", rule.Class)
+		}
+		if rule.MaxAttempts < 1 {
+			return nil, fmt.Errorf("retry rule for %s needs max_attempts of at least 1", rule.Class)
+		}
+		p.rules[rule.Class] = rule.MaxAttempts
+	}
+	return p, nil
+}
+
+// attemptsFor returns how many attempts a unit failing with class gets in total
+func (p *RetryPolicy) attemptsFor(class ErrorClass) int {
+	if n, ok := p.rules[class]; ok {
+		return n
+	}
+	if !class.Retryable() {
+		return 1
+	}
+	return p.maxAttempts
+}
+
+// backoff returns the wait after the given failed attempt: exponential growth
+// capped at maxBackoff, then spread by jitter so units that failed together
+// do not all retry in the same instant.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	wait := float64(p.initialBackoff)
+	for i := 1; i < attempt; i++ {
+		wait *= p.multiplier
+		if wait >= float64(p.maxBackoff) {
+			break
+		}
+	}
+	if wait > float64(p.maxBackoff) {
+		wait = float64(p.maxBackoff)
+	}
+
+	wait += wait * p.jitter * (2*rand.Float64() - 1)
+	return time.Duration(wait)
+}
+
+// retryBudget caps the retries of one cycle so a fleet-wide outage does not
+// multiply the cycle length by the number of attempts
+type retryBudget struct {
+	remaining int64
+}
+
+type retryBudgetKey struct{}
+
+// withBudget returns ctx carrying a fresh budget for one cycle. Without a
+// configured budget ctx is returned as is and retries are unlimited.
+func (p *RetryPolicy) withBudget(ctx context.Context) context.Context {
+	if p.budget <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, retryBudgetKey{}, &retryBudget{remaining: int64(p.budget)})
+}
+
+// takeRetry spends one retry from the cycle budget in ctx, if there is one
+func takeRetry(ctx context.Context) bool {
+	budget, ok := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	if !ok {
+		return true
+	}
+	return atomic.AddInt64(&budget.remaining, -1) >= 0
+}
+
+// Attempt is one try at polling a unit, kept with the result
+type Attempt struct {
+	Number     int        `json:"n"`
+	StartedAt  time.Time  `json:"started_at"`
+	DurationMs int64      `json:"duration_ms"`
+	ErrorClass ErrorClass `json:"error_class,omitempty"` // Empty for the attempt that succeeded
+	Error      string     `json:"error,omitempty"`
+}
+
+func newAttempt(number int, start time.Time, pollErr *PollError) Attempt {
+	a := Attempt{
+		Number:     number,
+		StartedAt:  start,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if pollErr != nil {
+		a.ErrorClass = pollErr.Class
+		a.Error = pollErr.Detail()
+	}
+	return a
+}
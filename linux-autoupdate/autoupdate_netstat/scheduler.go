@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultPollInterval = 5 * time.Minute
+
+// Scheduler runs polling cycles in daemon mode. It guarantees that a unit is
+// never polled twice at once, even when a slow sweep overlaps the next one.
+type Scheduler struct {
+	interval         time.Duration
+	cron             *cronSchedule
+	adaptiveInterval time.Duration
+	retry            *RetryPolicy
+
+	mu         sync.Mutex
+	servers    []Server
+	inFlight   map[string]bool
+	degraded   map[string]bool
+	sweeping   bool
+	reprobing  bool
+	fetch      func(ctx context.Context) ([]Server, error)
+	pollServer func(ctx context.Context, server Server) string
+	cycles     sync.WaitGroup
+}
+
+func newScheduler(cfg DaemonConfig, retry *RetryPolicy, fetch func(ctx context.Context) ([]Server, error), pollServer func(ctx context.Context, server Server) string) (*Scheduler, error) {
+	s := &Scheduler{
+		interval:         time.Duration(cfg.Interval),
+		adaptiveInterval: time.Duration(cfg.AdaptiveInterval),
+		retry:            retry,
+		inFlight:         make(map[string]bool),
+		degraded:         make(map[string]bool),
+		fetch:            fetch,
+		pollServer:       pollServer,
+	}
+
+	if cfg.Cron != "" {
+		c, err := parseCron(cfg.Cron)
+		if err != nil {
+			return nil, err
+		}
+		s.cron = c
+	} else if s.interval <= 0 {
+		s.interval = defaultPollInterval
+	}
+
+	return s, nil
+}
+
+// next returns when the next full sweep is due
+func (s *Scheduler) next(now time.Time) time.Time {
+	if s.cron != nil {
+		return s.cron.Next(now)
+	}
+	return now.Add(s.interval)
+}
+
+// Run starts full sweeps on schedule and, in adaptive mode, extra sweeps over
+// units that were SYN_SENT or unreachable last time. Once stop is done it
+// starts nothing new and returns when running cycles have finished; polls
+// themselves run under work, which is cancelled after the grace period.
+func (s *Scheduler) Run(stop, work context.Context) {
+	var adaptive <-chan time.Time
+	if s.adaptiveInterval > 0 {
+		ticker := time.NewTicker(s.adaptiveInterval)
+		defer ticker.Stop()
+		adaptive = ticker.C
+	}
+
+	// Interval mode sweeps straight away, cron mode waits for its first slot
+	nextSweep := time.Now()
+	if s.cron != nil {
+		nextSweep = s.next(nextSweep)
+	}
+	sweepTimer := time.NewTimer(time.Until(nextSweep))
+	defer sweepTimer.Stop()
+
+	for {
+		select {
+		case <-sweepTimer.C:
+			s.start(func() { s.sweep(stop, work) })
+			sweepTimer.Reset(time.Until(s.next(time.Now())))
+		case <-adaptive:
+			s.start(func() { s.reprobe(stop, work) })
+		case <-stop.Done():
+			s.cycles.Wait()
+			return
+		}
+	}
+}
+
+// start runs a cycle in the background, tracked so Run can wait for it
+func (s *Scheduler) start(cycle func()) {
+	s.cycles.Add(1)
+	go func() {
+		defer s.cycles.Done()
+		cycle()
+	}()
+}
+
+// sweep refreshes the server list and polls every unit
+func (s *Scheduler) sweep(stop, work context.Context) {
+	if !s.startRun(&s.sweeping) {
+		log.Println("Previous sweep still running, skipping this one")
+		return
+	}
+	defer s.endRun(&s.sweeping)
+
+	servers, err := s.fetch(stop)
+	s.mu.Lock()
+	if err != nil {
+		log.Printf("Failed to refresh server list, using last known list: %v", err)
+	} else {
+		s.servers = servers
+	}
+	servers = s.servers
+	s.mu.Unlock()
+
+	start := time.Now()
+	work = s.retry.withBudget(work)
+	runCycle(stop, servers, func(server Server) { s.poll(work, server) })
+	cycleDuration.WithLabelValues("full").Observe(time.Since(start).Seconds())
+	log.Printf("Sweep of %d units finished in %s", len(servers), time.Since(start))
+}
+
+// reprobe polls only the units that were degraded on their last poll
+func (s *Scheduler) reprobe(stop, work context.Context) {
+	if !s.startRun(&s.reprobing) {
+		return
+	}
+	defer s.endRun(&s.reprobing)
+
+	var due []Server
+	s.mu.Lock()
+	for _, server := range s.servers {
+		if s.degraded[server.Alias] {
+			due = append(due, server)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(due) > 0 {
+		log.Printf("Adaptive re-poll of %d degraded units", len(due))
+		start := time.Now()
+		work = s.retry.withBudget(work)
+		runCycle(stop, due, func(server Server) { s.poll(work, server) })
+		cycleDuration.WithLabelValues("adaptive").Observe(time.Since(start).Seconds())
+	}
+}
+
+// poll wraps pollServer with the per-unit in-flight guard and records the outcome
+func (s *Scheduler) poll(ctx context.Context, server Server) {
+	s.mu.Lock()
+	if s.inFlight[server.Alias] {
+		s.mu.Unlock()
+		log.Printf("Skipping %s (%s), previous poll still running", server.Alias, server.IP.String)
+		return
+	}
+	s.inFlight[server.Alias] = true
+	s.mu.Unlock()
+
+	status := s.pollServer(ctx, server)
+
+	s.mu.Lock()
+	delete(s.inFlight, server.Alias)
+	s.degraded[server.Alias] = isDegraded(status)
+	s.mu.Unlock()
+}
+
+func (s *Scheduler) startRun(running *bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if *running {
+		return false
+	}
+	*running = true
+	return true
+}
+
+func (s *Scheduler) endRun(running *bool) {
+	s.mu.Lock()
+	*running = false
+	s.mu.Unlock()
+}
+
+// isDegraded reports whether a status should be re-polled more often in adaptive mode
+func isDegraded(status string) bool {
+	return status == "SYN_SENT" || status == "Failed to Connect"
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpoolConfig keeps poll results on local disk while the database is unreachable
+type SpoolConfig struct {
+	Dir      string `json:"dir"`       // Where segment files are written, default "spool"
+	MaxBytes int64  `json:"max_bytes"` // Cap on the spool's size, the oldest results go first, default 256 MiB
+	Disabled bool   `json:"disabled"`  // Drop results the database refuses instead
+}
+
+const (
+	defaultSpoolDir      = "spool"
+	defaultSpoolMaxBytes = 256 << 20
+	spoolSegmentBytes    = 4 << 20 // A segment is closed and a new one started past this size
+	spoolRetryInterval   = 30 * time.Second
+	spoolSegmentSuffix   = ".jsonl"
+)
+
+// Spool is an append-only queue of poll results in numbered segment files,
+// one JSON result per line. Results are replayed oldest first and a segment
+// is deleted once all of it is in the database. A crash during replay may
+// write the results of one segment twice, never lose them.
+type Spool struct {
+	mu          sync.Mutex
+	dir         string
+	maxBytes    int64
+	segmentSize int64
+	segments    []spoolSegment // Oldest first, the last one is appended to
+	current     *os.File       // Open handle on the last segment, nil when a new one must be started
+	offset      int64          // Bytes of the oldest segment already replayed
+	size        int64
+	next        int
+}
+
+type spoolSegment struct {
+	name string
+	size int64
+}
+
+// openSpool picks up the segments left by a previous run. It returns nil
+// when spooling is disabled.
+func openSpool(cfg SpoolConfig) (*Spool, error) {
+	if cfg.Disabled {
+		return nil, nil
+	}
+
+	s := &Spool{dir: cfg.Dir, maxBytes: cfg.MaxBytes, next: 1}
+	if s.dir == "" {
+		s.dir = defaultSpoolDir
+	}
+	if s.maxBytes <= 0 {
+		s.maxBytes = defaultSpoolMaxBytes
+	}
+	// Keep segments small next to the cap, so dropping one frees little
+	s.segmentSize = spoolSegmentBytes
+	if s.segmentSize > s.maxBytes/4 {
+		s.segmentSize = s.maxBytes / 4
+	}
+
+	err := os.MkdirAll(s.dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool dir: %v", err)
+	}
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool dir: %v", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		seq, err := strconv.Atoi(strings.TrimSuffix(name, spoolSegmentSuffix))
+		if err != nil || !strings.HasSuffix(name, spoolSegmentSuffix) {
+			continue
+		}
+		s.segments = append(s.segments, spoolSegment{name: name, size: entry.Size()})
+		s.size += entry.Size()
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].name < s.segments[j].name })
+	spoolBytes.Set(float64(s.size))
+
+	if len(s.segments) > 0 {
+		log.Printf("Spool %s holds %d bytes of results to replay", s.dir, s.size)
+	}
+	return s, nil
+}
+
+// Pending reports whether results are waiting to be replayed
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments) > 0
+}
+
+// Append writes results to the newest segment and syncs it to disk. When
+// the spool grows past its cap the oldest segments are dropped; it returns
+// how many results that lost.
+func (s *Spool) Append(results []PollResult) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range results {
+		err := enc.Encode(r)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode result of %s: %v", r.Server.Alias, err)
+		}
+	}
+
+	if s.current == nil || s.segments[len(s.segments)-1].size >= s.segmentSize {
+		err := s.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	last := &s.segments[len(s.segments)-1]
+	n, err := s.current.Write(buf.Bytes())
+	last.size += int64(n)
+	s.size += int64(n)
+	if err == nil {
+		err = s.current.Sync()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to write spool: %v", err)
+	}
+
+	dropped := 0
+	for s.size > s.maxBytes && len(s.segments) > 1 {
+		dropped += s.dropOldest()
+	}
+	spoolBytes.Set(float64(s.size))
+	return dropped, nil
+}
+
+// rotate closes the segment being appended to and starts the next one
+func (s *Spool) rotate() error {
+	s.closeCurrent()
+	name := fmt.Sprintf("%016d%s", s.next, spoolSegmentSuffix)
+	file, err := os.OpenFile(filepath.Join(s.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %v", err)
+	}
+	s.next++
+	s.current = file
+	s.segments = append(s.segments, spoolSegment{name: name})
+	return nil
+}
+
+func (s *Spool) closeCurrent() {
+	if s.current == nil {
+		return
+	}
+	err := s.current.Close()
+	if err != nil {
+		log.Printf("Failed to close spool segment: %v", err)
+	}
+	s.current = nil
+}
+
+// dropOldest deletes the oldest segment to make room and returns the number
+// of results lost with it
+func (s *Spool) dropOldest() int {
+	seg := s.segments[0]
+	path := filepath.Join(s.dir, seg.name)
+	lost := 0
+	data, err := ioutil.ReadFile(path)
+	if err == nil && s.offset < int64(len(data)) {
+		lost = bytes.Count(data[s.offset:], []byte("\n"))
+	}
+	log.Printf("Spool is over %d bytes, dropping %d results of %s", s.maxBytes, lost, seg.name)
+	spoolDropped.Add(float64(lost))
+	s.removeOldest()
+	return lost
+}
+
+// removeOldest deletes the oldest segment file and forgets it
+func (s *Spool) removeOldest() {
+	seg := s.segments[0]
+	err := os.Remove(filepath.Join(s.dir, seg.name))
+	if err != nil {
+		log.Printf("Failed to remove spool segment %s: %v", seg.name, err)
+	}
+	s.segments = s.segments[1:]
+	s.size -= seg.size
+	s.offset = 0
+	spoolBytes.Set(float64(s.size))
+}
+
+// Replay sends the oldest segment to insert, batch results at a time and in
+// the order they were spooled. It stops at the first error; what is left is
+// tried again on the next call. It returns the number of results replayed.
+func (s *Spool) Replay(batch int, insert func([]PollResult) error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 {
+		return 0, nil
+	}
+	if len(s.segments) == 1 {
+		// New results go to a fresh segment while this one is read
+		s.closeCurrent()
+	}
+	seg := s.segments[0]
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, seg.name))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read spool segment: %v", err)
+	}
+
+	replayed := 0
+	var results []PollResult
+	var end int64 // Offset just past the results collected so far
+	flush := func() error {
+		if len(results) > 0 {
+			err := insert(results)
+			if err != nil {
+				return err
+			}
+			replayed += len(results)
+			spoolReplayed.Add(float64(len(results)))
+			results = results[:0]
+		}
+		s.offset = end
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data[s.offset:]))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data))
+	end = s.offset
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		end += int64(len(line)) + 1
+		var r PollResult
+		err := json.Unmarshal(line, &r)
+		if err != nil {
+			// A line cut short by a crash while it was written
+			log.Printf("Skipping unreadable result in spool segment %s: %v", seg.name, err)
+			continue
+		}
+		results = append(results, r)
+		if len(results) >= batch {
+			err := flush()
+			if err != nil {
+				return replayed, err
+			}
+		}
+	}
+	err = flush()
+	if err != nil {
+		return replayed, err
+	}
+
+	s.removeOldest()
+	return replayed, nil
+}
+
+// Close closes the segment being appended to
+func (s *Spool) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeCurrent()
+}
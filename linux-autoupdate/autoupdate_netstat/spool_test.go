@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func spoolResults(from, to int) []PollResult {
+	var results []PollResult
+	for i := from; i <= to; i++ {
+		results = append(results, PollResult{PollID: fmt.Sprintf("r%02d", i), Status: "ESTABLISHED"})
+	}
+	return results
+}
+
+// drainSpool replays everything in s and returns the poll IDs in replay order
+func drainSpool(t *testing.T, s *Spool, batch int) []string {
+	t.Helper()
+	var ids []string
+	for s.Pending() {
+		_, err := s.Replay(batch, func(results []PollResult) error {
+			for _, r := range results {
+				ids = append(ids, r.PollID)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Replay() error = %v", err)
+		}
+	}
+	return ids
+}
+
+func pollIDs(results []PollResult) []string {
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.PollID)
+	}
+	return ids
+}
+
+func TestSpoolReplayOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks [][2]int // Results appended per call
+		batch  int
+		reopen bool // Close and reopen the spool before replaying, as after a restart
+	}{
+		{name: "single append", chunks: [][2]int{{1, 5}}, batch: 100},
+		{name: "several appends", chunks: [][2]int{{1, 3}, {4, 4}, {5, 9}}, batch: 2},
+		{name: "after restart", chunks: [][2]int{{1, 4}, {5, 8}}, batch: 3, reopen: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := SpoolConfig{Dir: t.TempDir()}
+			s, err := openSpool(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			var want []string
+			for _, chunk := range tt.chunks {
+				results := spoolResults(chunk[0], chunk[1])
+				want = append(want, pollIDs(results)...)
+				_, err := s.Append(results)
+				if err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+			}
+			if tt.reopen {
+				s.Close()
+				s, err = openSpool(cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := drainSpool(t, s, tt.batch)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("replayed %v, want %v", got, want)
+			}
+			entries, _ := ioutil.ReadDir(cfg.Dir)
+			if len(entries) != 0 {
+				t.Errorf("%d segment files left after replay", len(entries))
+			}
+		})
+	}
+}
+
+func TestSpoolCap(t *testing.T) {
+	line, err := json.Marshal(spoolResults(1, 1)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for 8 results in segments of 2
+	maxBytes := int64(8 * (len(line) + 1))
+
+	tests := []struct {
+		appended    int
+		wantDropped int
+		wantFirst   int
+	}{
+		{appended: 8, wantDropped: 0, wantFirst: 1},
+		{appended: 9, wantDropped: 2, wantFirst: 3},
+		{appended: 12, wantDropped: 4, wantFirst: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.appended), func(t *testing.T) {
+			s, err := openSpool(SpoolConfig{Dir: t.TempDir(), MaxBytes: maxBytes})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			dropped := 0
+			for i := 1; i <= tt.appended; i++ {
+				n, err := s.Append(spoolResults(i, i))
+				if err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+				dropped += n
+			}
+			if dropped != tt.wantDropped {
+				t.Errorf("dropped %d results, want %d", dropped, tt.wantDropped)
+			}
+
+			got := drainSpool(t, s, 100)
+			want := pollIDs(spoolResults(tt.wantFirst, tt.appended))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("replayed %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSpoolReplayResumesAfterError(t *testing.T) {
+	s, err := openSpool(SpoolConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	_, err = s.Append(spoolResults(1, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second batch fails once, as when the database goes away mid-replay
+	var ids []string
+	calls := 0
+	insert := func(results []PollResult) error {
+		calls++
+		if calls == 2 {
+			return errors.New("database is down")
+		}
+		ids = append(ids, pollIDs(results)...)
+		return nil
+	}
+
+	n, err := s.Replay(2, insert)
+	if err == nil || n != 2 {
+		t.Fatalf("Replay() = %d, %v, want 2 and an error", n, err)
+	}
+	n, err = s.Replay(2, insert)
+	if err != nil || n != 3 {
+		t.Fatalf("Replay() = %d, %v, want 3 and no error", n, err)
+	}
+
+	want := pollIDs(spoolResults(1, 5))
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("replayed %v, want %v", ids, want)
+	}
+	if s.Pending() {
+		t.Error("spool still pending after a full replay")
+	}
+}
+
+func TestSpoolSkipsTruncatedLine(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Append(spoolResults(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// A crash while a line was written leaves it cut short
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentSuffix))
+	if len(segments) != 1 {
+		t.Fatalf("found %d segments, want 1", len(segments))
+	}
+	f, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"PollID":"r03","Sta`)
+	f.Close()
+
+	s, err = openSpool(SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	got := drainSpool(t, s, 100)
+	want := []string{"r01", "r02"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
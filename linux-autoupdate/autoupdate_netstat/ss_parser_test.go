@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSS(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Connection
+		err    error
+	}{
+		{
+			name: "current ss",
+			output: `State      Recv-Q Send-Q Local Address:Port   Peer Address:Port
+LISTEN     0      128          0.0.0.0:22            0.0.0.0:*
+ESTAB      0      36          10.0.0.5:22           10.0.0.1:51234
+SYN-SENT   0      1           10.0.0.5:40000       10.0.0.9:502
+TIME-WAIT  0      0           10.0.0.5:40001       10.0.0.9:502
+`,
+			want: []Connection{
+				{Proto: "tcp", LocalAddress: "0.0.0.0", LocalPort: "22", ForeignAddress: "0.0.0.0", ForeignPort: "*", State: "LISTEN", SendQ: 128},
+				{Proto: "tcp", SendQ: 36, LocalAddress: "10.0.0.5", LocalPort: "22", ForeignAddress: "10.0.0.1", ForeignPort: "51234", State: "ESTABLISHED"},
+				{Proto: "tcp", SendQ: 1, LocalAddress: "10.0.0.5", LocalPort: "40000", ForeignAddress: "10.0.0.9", ForeignPort: "502", State: "SYN_SENT"},
+				{Proto: "tcp", LocalAddress: "10.0.0.5", LocalPort: "40001", ForeignAddress: "10.0.0.9", ForeignPort: "502", State: "TIME_WAIT"},
+			},
+		},
+		{
+			name: "older ss with netid column",
+			output: `Netid State      Recv-Q Send-Q Local Address:Port   Peer Address:Port
+tcp   CLOSE-WAIT 3      0          10.0.0.5:22           10.0.0.1:51234
+`,
+			want: []Connection{
+				{Proto: "tcp", RecvQ: 3, LocalAddress: "10.0.0.5", LocalPort: "22", ForeignAddress: "10.0.0.1", ForeignPort: "51234", State: "CLOSE_WAIT"},
+			},
+		},
+		{
+			name: "ipv6 with and without brackets",
+			output: `State      Recv-Q Send-Q Local Address:Port   Peer Address:Port
+ESTAB      0      0      [::1]:631            [::1]:40000
+FIN-WAIT-2 0      0      fe80::1%eth0:22      fe80::2:50000
+`,
+			want: []Connection{
+				{Proto: "tcp6", LocalAddress: "::1", LocalPort: "631", ForeignAddress: "::1", ForeignPort: "40000", State: "ESTABLISHED"},
+				{Proto: "tcp6", LocalAddress: "fe80::1%eth0", LocalPort: "22", ForeignAddress: "fe80::2", ForeignPort: "50000", State: "FIN_WAIT2"},
+			},
+		},
+		{
+			name: "unknown states and bad queues are skipped",
+			output: `State      Recv-Q Send-Q Local Address:Port   Peer Address:Port
+BOGUS      0      0          10.0.0.5:22           10.0.0.1:51234
+ESTAB      x      0          10.0.0.5:22           10.0.0.1:51234
+`,
+		},
+		{
+			name:   "idle unit prints only the header",
+			output: "State      Recv-Q Send-Q Local Address:Port   Peer Address:Port\n",
+		},
+		{
+			name:   "not ss output",
+			output: "sh: ss: not found\n",
+			err:    errUnrecognizedOutput,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSS([]byte(tt.output))
+			if err != tt.err {
+				t.Fatalf("parseSS() error = %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSS() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
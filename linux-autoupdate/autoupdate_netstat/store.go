@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DatabaseConfig selects the storage backend for poll results
+type DatabaseConfig struct {
+	Driver string `json:"driver"` // mysql, postgres or sqlite
+	DSN    string `json:"dsn"`
+}
+
+// StatusRow is one display_status row as read back from a store
+type StatusRow struct {
+	ID             int64  `json:"id"`
+	DateTime       string `json:"date_time"`
+	IDUnit         string `json:"id_unit"`
+	IPUnit         string `json:"ip_unit"`
+	ForeignAddress string `json:"foreign_address"`
+	Status         string `json:"status"`
+	ErrorClass     string `json:"error_class"`
+}
+
+// PollResult is everything learned about one unit in one poll
+type PollResult struct {
+	PollID         string    // Links the display_status row to its connections and targets
+	PolledAt       time.Time // When the poll ended, stored as date_time even if the row is written late
+	Server         Server
+	ForeignAddress string // Raw IP:port of the primary target
+	ForeignName    string // Logical name of ForeignAddress, from the names config
+	Status         string
+	ErrorClass     ErrorClass
+	ErrorDetail    string
+	Strategy       string // Collector strategy that listed the connections
+	Connections    []Connection
+	Targets        []TargetResult
+	StateCounts    map[string]int // Connections to the primary target by TCP state
+	Attempts       []Attempt      // Every try, the last one gave this result
+	Reach          []PortReach    // Collector-side TCP probe, SSH port first, nil when not probed
+	Transition     *Transition    // Status change since the unit's previous poll, if any
+	Heartbeat      bool           // Same state as the last recorded row, only refresh unit_latest.last_seen
+}
+
+// Store persists poll results. Each backend owns its schema and its own
+// "latest status per unit" query.
+type Store interface {
+	// Init applies every pending schema migration
+	Init() error
+	// MigrateDown reverts the latest applied migration
+	MigrateDown() error
+	// Migrations lists the known migrations and which are applied
+	Migrations() ([]MigrationStatus, error)
+	// InsertResults stores a batch of poll results in one transaction
+	InsertResults(ctx context.Context, results []PollResult) error
+	// LatestStatus returns the most recent display_status row for every unit
+	LatestStatus(ctx context.Context) ([]StatusRow, error)
+	// LatestTransitions returns the most recent status_transitions row for every unit
+	LatestTransitions(ctx context.Context) ([]Transition, error)
+	// Retain rolls up, archives and deletes raw rows past the retention policy
+	Retain(ctx context.Context, policy *RetentionPolicy) error
+	// LoadBreakers returns the saved circuit breaker of every unit
+	LoadBreakers(ctx context.Context) ([]BreakerState, error)
+	// SaveBreaker stores the circuit breaker of one unit
+	SaveBreaker(ctx context.Context, state BreakerState) error
+	Close() error
+}
+
+// newStore opens the backend named in cfg
+func newStore(cfg DatabaseConfig) (Store, error) {
+	switch cfg.Driver {
+	case "", "mysql":
+		return newMySQLStore(cfg.DSN)
+	case "postgres":
+		return newPostgresStore(cfg.DSN)
+	case "sqlite":
+		return newSQLiteStore(cfg.DSN)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+}
+
+// maxBindParams keeps multi-row INSERTs below the placeholder limit of every backend
+const maxBindParams = 30000
+
+// dialect describes what differs between the SQL backends
+type dialect struct {
+	driver        string
+	columns       []column // Columns older collectors added outside migrations, see addLegacyColumns
+	latestQuery   string
+	upsertBreaker string // Insert or replace one unit_breakers row
+	upsertLatest  string // Insert or replace one unit_latest row
+	unixNow       string // The database's current Unix time in seconds
+	numbered      bool   // Uses $1, $2... placeholders instead of ?
+}
+
+// latestStatusIDs selects the id of each unit's newest display_status row
+// matching where. Newest is by date_time, then id, as in the APIs: replayed
+// results are inserted late but keep the date_time of their poll. Written
+// without window functions so it runs on MySQL 5.7.
+func latestStatusIDs(where string) string {
+	return `
+		SELECT MAX(d.id) AS id
+		FROM display_status d
+		INNER JOIN (
+			SELECT id_unit, MAX(date_time) AS date_time
+			FROM display_status
+			WHERE ` + where + `
+			GROUP BY id_unit
+		) newest ON d.id_unit = newest.id_unit AND d.date_time = newest.date_time
+		GROUP BY d.id_unit`
+}
+
+// column is a column that adopting a pre-migration database adds when missing
+type column struct {
+	table, name, definition string
+}
+
+// sqlStore implements Store on top of database/sql for a given dialect
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func openSQLStore(d dialect, dsn string) (*sqlStore, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStore{db: db, dialect: d}, nil
+}
+
+// rebind rewrites ? placeholders for dialects that number them
+func (s *sqlStore) rebind(query string) string {
+	if !s.dialect.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *sqlStore) InsertResults(ctx context.Context, results []PollResult) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	dbTime, unixSkew, err := s.clock(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	var statusRows, connectionRows, targetRows, transitionRows, latestRows [][]interface{}
+	heartbeats := make(map[string][]interface{}) // Units by last_seen
+	for _, r := range results {
+		at := dbTime(r.PolledAt)
+		if r.Heartbeat {
+			heartbeats[at] = append(heartbeats[at], r.Server.Alias)
+			continue
+		}
+		latestRows = append(latestRows, []interface{}{r.Server.Alias, r.PollID, r.Server.IP.String, r.Status, r.ForeignAddress, string(r.ErrorClass), at})
+
+		history, err := json.Marshal(r.Attempts)
+		if err != nil {
+			return fmt.Errorf("failed to encode attempts of %s: %v", r.Server.Alias, err)
+		}
+		sshReachable, sshLatency, reach := reachColumns(r.Reach)
+		statusRows = append(statusRows, []interface{}{at, r.PollID, r.Server.Alias, r.Server.IP.String, r.ForeignAddress, r.ForeignName, r.Status, stateCounts(r.StateCounts), string(r.ErrorClass), r.ErrorDetail, len(r.Attempts), string(history), sshReachable, sshLatency, reach})
+		for _, c := range r.Connections {
+			connectionRows = append(connectionRows, []interface{}{at, r.PollID, r.Server.Alias, c.Proto, c.RecvQ, c.SendQ, c.LocalAddress, c.LocalPort, c.ForeignAddress, c.ForeignPort, c.ForeignName, c.State})
+		}
+		for _, t := range r.Targets {
+			targetRows = append(targetRows, []interface{}{at, r.PollID, r.Server.Alias, t.Target, t.ForeignAddress, t.ForeignName, t.Status, stateCounts(t.States)})
+		}
+		if t := r.Transition; t != nil {
+			var duration interface{}
+			if t.Duration >= 0 {
+				duration = t.Duration
+			}
+			transitionRows = append(transitionRows, []interface{}{r.PollID, t.Unit, t.From, t.To, t.ChangedAt + unixSkew, duration})
+		}
+	}
+
+	err = s.insertRows(ctx, tx, "display_status", []string{"date_time", "poll_id", "id_unit", "ip_unit", "foreign_address", "foreign_name", "status", "state_counts", "error_class", "error_detail", "attempts", "attempt_history", "ssh_reachable", "ssh_latency_ms", "reachability"}, statusRows)
+	if err != nil {
+		return err
+	}
+	err = s.insertRows(ctx, tx, "display_connections", []string{"date_time", "poll_id", "id_unit", "proto", "recv_q", "send_q", "local_address", "local_port", "foreign_address", "foreign_port", "foreign_name", "state"}, connectionRows)
+	if err != nil {
+		return err
+	}
+	err = s.insertRows(ctx, tx, "display_targets", []string{"date_time", "poll_id", "id_unit", "target", "foreign_address", "foreign_name", "status", "state_counts"}, targetRows)
+	if err != nil {
+		return err
+	}
+	err = s.insertRows(ctx, tx, "status_transitions", []string{"poll_id", "id_unit", "from_status", "to_status", "changed_at", "duration_seconds"}, transitionRows)
+	if err != nil {
+		return err
+	}
+
+	// unit_latest points at each unit's latest recorded row and when the unit
+	// was last seen in that state
+	for _, row := range latestRows {
+		_, err = tx.ExecContext(ctx, s.rebind(s.dialect.upsertLatest), row...)
+		if err != nil {
+			return fmt.Errorf("failed to update unit_latest: %v", err)
+		}
+	}
+	for at, units := range heartbeats {
+		for start := 0; start < len(units); start += maxBindParams - 1 {
+			end := start + maxBindParams - 1
+			if end > len(units) {
+				end = len(units)
+			}
+			placeholders := strings.TrimSuffix(strings.Repeat("?, ", end-start), ", ")
+			query := "UPDATE unit_latest SET last_seen = ? WHERE id_unit IN (" + placeholders + ")"
+			_, err = tx.ExecContext(ctx, s.rebind(query), append([]interface{}{at}, units[start:end]...)...)
+			if err != nil {
+				return fmt.Errorf("failed to update unit_latest: %v", err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// queryer is a *sql.DB or a *sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// clock returns a function that writes a collector time as a timestamp in
+// the database's clock and zone, the ones CURRENT_TIMESTAMP defaults use, so
+// rows replayed from the spool sort among the others by when they were polled.
+// It also returns the seconds to add to a collector Unix time to put it on
+// the database's clock, for status_transitions.changed_at.
+func (s *sqlStore) clock(ctx context.Context, q queryer) (func(time.Time) string, int64, error) {
+	var nowText string
+	var dbUnix int64
+	err := q.QueryRowContext(ctx, "SELECT CURRENT_TIMESTAMP, "+s.dialect.unixNow).Scan(&nowText, &dbUnix)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read database time: %v", err)
+	}
+	dbNow, err := parseDBTime(nowText)
+	if err != nil {
+		return nil, 0, err
+	}
+	now := time.Now().Truncate(time.Second)
+	skew := dbNow.Truncate(time.Second).Sub(now)
+
+	return func(t time.Time) string {
+		if t.IsZero() {
+			t = time.Now()
+		}
+		return t.Add(skew).UTC().Format(dbTimeLayout)
+	}, dbUnix - now.Unix(), nil
+}
+
+// stateCounts encodes connection counts by TCP state as JSON, or NULL when
+// there were no connections to the target
+func stateCounts(counts map[string]int) interface{} {
+	if len(counts) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(counts)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
+
+// reachColumns splits a reach probe into the ssh_reachable, ssh_latency_ms
+// and reachability values, all NULL when the unit was not probed
+func reachColumns(reach []PortReach) (interface{}, interface{}, interface{}) {
+	if len(reach) == 0 {
+		return nil, nil, nil
+	}
+	var latency interface{}
+	if reach[0].Open {
+		latency = reach[0].LatencyMs
+	}
+	data, err := json.Marshal(reach)
+	if err != nil {
+		return reach[0].Open, latency, nil
+	}
+	return reach[0].Open, latency, string(data)
+}
+
+// insertRows writes rows with as few multi-row INSERT statements as the
+// placeholder limit allows
+func (s *sqlStore) insertRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	rowPlaceholder := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	perStatement := maxBindParams / len(columns)
+
+	for start := 0; start < len(rows); start += perStatement {
+		end := start + perStatement
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for _, row := range rows[start:end] {
+			placeholders = append(placeholders, rowPlaceholder)
+			args = append(args, row...)
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+		_, err := tx.ExecContext(ctx, s.rebind(query), args...)
+		if err != nil {
+			return fmt.Errorf("failed to insert into %s: %v", table, err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) LatestStatus(ctx context.Context) ([]StatusRow, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.latestQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var latest []StatusRow
+	for rows.Next() {
+		var r StatusRow
+		err := rows.Scan(&r.ID, &r.DateTime, &r.IDUnit, &r.IPUnit, &r.ForeignAddress, &r.Status, &r.ErrorClass)
+		if err != nil {
+			return nil, err
+		}
+		latest = append(latest, r)
+	}
+	return latest, rows.Err()
+}
+
+// LatestTransitions returns each unit's last transition, with changed_at
+// moved back to the collector's clock the tracker works in
+func (s *sqlStore) LatestTransitions(ctx context.Context) ([]Transition, error) {
+	_, unixSkew, err := s.clock(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT st.id_unit, st.from_status, st.to_status, st.changed_at, COALESCE(st.duration_seconds, -1)
+		FROM status_transitions st
+		WHERE st.id = (SELECT MAX(id) FROM status_transitions WHERE id_unit = st.id_unit)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var latest []Transition
+	for rows.Next() {
+		var t Transition
+		err := rows.Scan(&t.Unit, &t.From, &t.To, &t.ChangedAt, &t.Duration)
+		if err != nil {
+			return nil, err
+		}
+		t.ChangedAt -= unixSkew
+		latest = append(latest, t)
+	}
+	return latest, rows.Err()
+}
+
+func (s *sqlStore) LoadBreakers(ctx context.Context) ([]BreakerState, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id_unit, state, failures, opened_at, next_probe FROM unit_breakers")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []BreakerState
+	for rows.Next() {
+		var st BreakerState
+		err := rows.Scan(&st.Unit, &st.State, &st.Failures, &st.OpenedAt, &st.NextProbe)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, st)
+	}
+	return states, rows.Err()
+}
+
+func (s *sqlStore) SaveBreaker(ctx context.Context, st BreakerState) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(s.dialect.upsertBreaker), st.Unit, st.State, st.Failures, st.OpenedAt, st.NextProbe)
+	return err
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	_ "github.com/lib/pq" // PostgreSQL driver
+)
+
+var postgresDialect = dialect{
+	driver: "postgres",
+	columns: []column{
+		{"display_status", "poll_id", "VARCHAR(32)"},
+		{"display_status", "error_class", "VARCHAR(64)"},
+		{"display_status", "error_detail", "VARCHAR(1024)"},
+		{"display_status", "attempts", "INT"},
+		{"display_status", "attempt_history", "TEXT"},
+		{"display_status", "foreign_name", "VARCHAR(255)"},
+		{"display_connections", "foreign_name", "VARCHAR(255)"},
+		{"display_targets", "foreign_name", "VARCHAR(255)"},
+		{"display_status", "state_counts", "TEXT"},
+		{"display_targets", "state_counts", "TEXT"},
+		{"display_status", "ssh_reachable", "BOOLEAN"},
+		{"display_status", "ssh_latency_ms", "DOUBLE PRECISION"},
+		{"display_status", "reachability", "TEXT"},
+	},
+	latestQuery: `
+		SELECT DISTINCT ON (id_unit) id, date_time, id_unit, ip_unit, foreign_address, status, COALESCE(error_class, '')
+		FROM display_status
+		ORDER BY id_unit, date_time DESC, id DESC;
+	`,
+	upsertBreaker: `
+		INSERT INTO unit_breakers (id_unit, state, failures, opened_at, next_probe)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id_unit) DO UPDATE SET
+			state = excluded.state, failures = excluded.failures,
+			opened_at = excluded.opened_at, next_probe = excluded.next_probe;
+	`,
+	upsertLatest: `
+		INSERT INTO unit_latest (id_unit, poll_id, ip_unit, status, foreign_address, error_class, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id_unit) DO UPDATE SET
+			poll_id = excluded.poll_id, ip_unit = excluded.ip_unit, status = excluded.status,
+			foreign_address = excluded.foreign_address, error_class = excluded.error_class, last_seen = excluded.last_seen;
+	`,
+	unixNow:  "CAST(EXTRACT(EPOCH FROM CURRENT_TIMESTAMP) AS BIGINT)",
+	numbered: true,
+}
+
+func newPostgresStore(dsn string) (Store, error) {
+	return openSQLStore(postgresDialect, dsn)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dbTimeLayout is how timestamps are passed to every backend in queries
+const dbTimeLayout = "2006-01-02 15:04:05"
+
+// parseDBTime reads a timestamp scanned into a string. Drivers return either
+// "2006-01-02 15:04:05" or RFC 3339; both are taken as wall-clock time in the
+// database's zone, which is the zone every date_time default is written in.
+func parseDBTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", s)
+}
+
+// Retain rolls raw rows older than the policy's age into status_hourly, then
+// archives and deletes them. Every step runs in small batches and waits out
+// blackout windows, so the collector and the APIs keep their access to the tables.
+func (s *sqlStore) Retain(ctx context.Context, p *RetentionPolicy) error {
+	if !p.wait(ctx) {
+		return ctx.Err()
+	}
+
+	// Work in the database's clock, the one date_time is written with
+	var nowText string
+	err := s.db.QueryRowContext(ctx, "SELECT CURRENT_TIMESTAMP").Scan(&nowText)
+	if err != nil {
+		return fmt.Errorf("failed to read database time: %v", err)
+	}
+	now, err := parseDBTime(nowText)
+	if err != nil {
+		return err
+	}
+	cutoff := now.Add(-p.rawAge).Truncate(time.Hour)
+
+	rolled, err := s.rollup(ctx, p, cutoff)
+	if err != nil {
+		return fmt.Errorf("rollup failed: %v", err)
+	}
+
+	deleted := 0
+	for _, table := range []string{"display_status", "display_connections", "display_targets"} {
+		n, err := s.purge(ctx, p, table, cutoff)
+		deleted += n
+		if err != nil {
+			return fmt.Errorf("failed to purge %s: %v", table, err)
+		}
+	}
+	log.Printf("Retention: %d hourly rows rolled up, %d raw rows removed before %s", rolled, deleted, cutoff.Format(dbTimeLayout))
+	return nil
+}
+
+// rollup fills status_hourly from where it left off up to cutoff, a day of
+// raw rows at a time. Each day is written in one transaction, so a run that
+// stops halfway resumes without counting an hour twice.
+func (s *sqlStore) rollup(ctx context.Context, p *RetentionPolicy, cutoff time.Time) (int, error) {
+	from, err := s.rollupStart(ctx)
+	if err != nil || from.IsZero() {
+		return 0, err
+	}
+
+	total := 0
+	for from.Before(cutoff) {
+		if !p.wait(ctx) {
+			return total, ctx.Err()
+		}
+		to := from.Add(rollupChunk)
+		if to.After(cutoff) {
+			to = cutoff
+		}
+
+		anchors, err := s.anchors(ctx, from)
+		if err != nil {
+			return total, err
+		}
+		points, err := s.points(ctx, from, to)
+		if err != nil {
+			return total, err
+		}
+
+		var rows [][]interface{}
+		for _, h := range rollupHours(anchors, points, from, to) {
+			rows = append(rows, []interface{}{h.Unit, h.HourStart.Format(dbTimeLayout), h.Status, h.Minutes, h.Drops})
+		}
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return total, err
+		}
+		err = s.insertRows(ctx, tx, "status_hourly", []string{"id_unit", "hour_start", "status", "minutes", "drops"}, rows)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return total, err
+		}
+		total += len(rows)
+		from = to
+	}
+	return total, nil
+}
+
+// rollupStart is the first hour not yet in status_hourly, or the hour of the
+// oldest raw row on the first run. It is zero when there is nothing to roll up.
+func (s *sqlStore) rollupStart(ctx context.Context) (time.Time, error) {
+	var last sql.NullString
+	err := s.db.QueryRowContext(ctx, "SELECT MAX(hour_start) FROM status_hourly").Scan(&last)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if last.Valid {
+		t, err := parseDBTime(last.String)
+		return t.Add(time.Hour), err
+	}
+
+	var first sql.NullString
+	err = s.db.QueryRowContext(ctx, "SELECT MIN(date_time) FROM display_status").Scan(&first)
+	if err != nil || !first.Valid {
+		return time.Time{}, err
+	}
+	t, err := parseDBTime(first.String)
+	return t.Truncate(time.Hour), err
+}
+
+// anchors returns each unit's status from its last row before t
+func (s *sqlStore) anchors(ctx context.Context, t time.Time) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`
+		SELECT ds.id_unit, COALESCE(ds.status, '')
+		FROM display_status ds
+		INNER JOIN (`+latestStatusIDs("date_time < ?")+`
+		) latest ON ds.id = latest.id`), t.Format(dbTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	anchors := make(map[string]string)
+	for rows.Next() {
+		var unit, status string
+		err := rows.Scan(&unit, &status)
+		if err != nil {
+			return nil, err
+		}
+		anchors[unit] = status
+	}
+	return anchors, rows.Err()
+}
+
+// points returns the raw rows in [from, to) by unit, oldest first
+func (s *sqlStore) points(ctx context.Context, from, to time.Time) ([]statusPoint, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`
+		SELECT id_unit, COALESCE(status, ''), date_time
+		FROM display_status
+		WHERE date_time >= ? AND date_time < ?
+		ORDER BY id_unit, date_time, id`), from.Format(dbTimeLayout), to.Format(dbTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []statusPoint
+	for rows.Next() {
+		var p statusPoint
+		var at string
+		err := rows.Scan(&p.unit, &p.status, &at)
+		if err != nil {
+			return nil, err
+		}
+		p.at, err = parseDBTime(at)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// purge archives, if configured, and deletes the rows of table older than
+// cutoff, batchSize rows per statement. Each unit's last display_status row is
+// kept: it is the unit's current state for change-only recording, the APIs'
+// latest status and the next rollup's anchor.
+func (s *sqlStore) purge(ctx context.Context, p *RetentionPolicy, table string, cutoff time.Time) (int, error) {
+	keep := make(map[int64]bool)
+	if table == "display_status" {
+		rows, err := s.db.QueryContext(ctx, s.rebind(latestStatusIDs("date_time < ?")), cutoff.Format(dbTimeLayout))
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var id int64
+			err := rows.Scan(&id)
+			if err != nil {
+				rows.Close()
+				return 0, err
+			}
+			keep[id] = true
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return 0, rows.Err()
+		}
+	}
+
+	var archive *archiveFile
+	defer func() {
+		if archive != nil {
+			err := archive.Close()
+			if err != nil {
+				log.Printf("Failed to close %s archive: %v", table, err)
+			}
+		}
+	}()
+
+	query := s.rebind(fmt.Sprintf("SELECT * FROM %s WHERE date_time < ? AND id > ? ORDER BY id LIMIT %d", table, p.batchSize))
+	var lastID int64
+	deleted := 0
+	for {
+		columns, batch, err := s.selectRows(ctx, query, cutoff.Format(dbTimeLayout), lastID)
+		if err != nil {
+			return deleted, err
+		}
+		if len(batch) == 0 {
+			return deleted, nil
+		}
+
+		idColumn := -1
+		for i, c := range columns {
+			if c == "id" {
+				idColumn = i
+			}
+		}
+		if idColumn < 0 {
+			return deleted, fmt.Errorf("%s has no id column", table)
+		}
+
+		var ids []interface{}
+		var removed [][]interface{}
+		for _, row := range batch {
+			id, err := strconv.ParseInt(fmt.Sprint(row[idColumn]), 10, 64)
+			if err != nil {
+				return deleted, fmt.Errorf("unexpected id %v: %v", row[idColumn], err)
+			}
+			lastID = id
+			if !keep[id] {
+				ids = append(ids, id)
+				removed = append(removed, row)
+			}
+		}
+
+		// Rows reach the archive file before they leave the database
+		if p.archive != "" && len(removed) > 0 {
+			if archive == nil {
+				archive, err = createArchiveFile(p.archiveDir, table, p.archive, columns)
+				if err != nil {
+					return deleted, err
+				}
+			}
+			err = archive.Write(removed)
+			if err != nil {
+				return deleted, err
+			}
+		}
+		if len(ids) > 0 {
+			placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+			_, err = s.db.ExecContext(ctx, s.rebind(fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", table, placeholders)), ids...)
+			if err != nil {
+				return deleted, err
+			}
+			deleted += len(ids)
+		}
+
+		if len(batch) < p.batchSize || !p.pause(ctx) {
+			return deleted, ctx.Err()
+		}
+	}
+}
+
+// selectRows reads a whole result set with its column names. Text comes
+// back as strings and timestamps in dbTimeLayout, whatever the driver.
+func (s *sqlStore) selectRows(ctx context.Context, query string, args ...interface{}) ([]string, [][]interface{}, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		err := rows.Scan(ptrs...)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, v := range values {
+			switch v := v.(type) {
+			case []byte:
+				values[i] = string(v)
+			case time.Time:
+				values[i] = v.Format(dbTimeLayout)
+			}
+		}
+		result = append(result, values)
+	}
+	return columns, result, rows.Err()
+}
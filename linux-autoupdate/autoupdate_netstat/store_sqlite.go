@@ -0,0 +1,59 @@
+package main
+
+import (
+	_ "modernc.org/sqlite" // Embedded SQLite driver, no cgo needed
+)
+
+var sqliteDialect = dialect{
+	driver: "sqlite",
+	columns: []column{
+		{"display_status", "poll_id", "TEXT"},
+		{"display_status", "error_class", "TEXT"},
+		{"display_status", "error_detail", "TEXT"},
+		{"display_status", "attempts", "INTEGER"},
+		{"display_status", "attempt_history", "TEXT"},
+		{"display_status", "foreign_name", "TEXT"},
+		{"display_connections", "foreign_name", "TEXT"},
+		{"display_targets", "foreign_name", "TEXT"},
+		{"display_status", "state_counts", "TEXT"},
+		{"display_targets", "state_counts", "TEXT"},
+		{"display_status", "ssh_reachable", "INTEGER"},
+		{"display_status", "ssh_latency_ms", "REAL"},
+		{"display_status", "reachability", "TEXT"},
+	},
+	latestQuery: `
+		SELECT ds.id, ds.date_time, ds.id_unit, ds.ip_unit, ds.foreign_address, ds.status, COALESCE(ds.error_class, '')
+		FROM display_status ds
+		INNER JOIN (` + latestStatusIDs("1 = 1") + `
+		) latest ON ds.id = latest.id
+		ORDER BY ds.id_unit;
+	`,
+	upsertBreaker: `
+		INSERT INTO unit_breakers (id_unit, state, failures, opened_at, next_probe)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id_unit) DO UPDATE SET
+			state = excluded.state, failures = excluded.failures,
+			opened_at = excluded.opened_at, next_probe = excluded.next_probe;
+	`,
+	upsertLatest: `
+		INSERT INTO unit_latest (id_unit, poll_id, ip_unit, status, foreign_address, error_class, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id_unit) DO UPDATE SET
+			poll_id = excluded.poll_id, ip_unit = excluded.ip_unit, status = excluded.status,
+			foreign_address = excluded.foreign_address, error_class = excluded.error_class, last_seen = excluded.last_seen;
+	`,
+	unixNow: "CAST(strftime('%s', 'now') AS INTEGER)",
+}
+
+func newSQLiteStore(dsn string) (Store, error) {
+	if dsn == "" {
+		dsn = "netstat.db"
+	}
+	s, err := openSQLStore(sqliteDialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, serialise access instead of failing with SQLITE_BUSY
+	s.db.SetMaxOpenConns(1)
+	return s, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCollectorNames(t *testing.T) {
+	tests := []struct {
+		name         string
+		configured   []string
+		unnamedHosts []string
+		want         []string
+		wantErr      bool
+	}{
+		{name: "defaults", want: nil},
+		{name: "configured", configured: []string{"proc", "ss"}, want: []string{"proc", "ss"}},
+		{
+			name:         "unnamed host with netstat-names",
+			configured:   []string{"ss", "netstat-names"},
+			unnamedHosts: []string{"master"},
+			want:         []string{"ss", "netstat-names"},
+		},
+		{name: "unnamed host with the defaults", unnamedHosts: []string{"master"}, wantErr: true},
+		{name: "unnamed host with numeric collectors", configured: []string{"ss", "netstat"}, unnamedHosts: []string{"master"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := collectorNames(tt.configured, tt.unnamedHosts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("collectorNames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("collectorNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Config holds collector settings read from a JSON file
+type Config struct {
+	Matchers        []MatcherConfig `json:"matchers"`
+	Groups          []GroupConfig   `json:"groups"`
+	CredentialsFile string          `json:"credentials_file"` // Per-unit and per-group SSH credentials
+	HostKeys        HostKeyConfig   `json:"host_keys"`
+}
+
+// loadConfig reads the config file at path. A missing file is not an error,
+// the collector then runs with its built-in defaults.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	err = json.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrorClass says why a poll did not produce a netstat result. It is stored
+// in display_status.error_class with the same values the main collector uses.
+type ErrorClass string
+
+const (
+	ClassNoCredentials     ErrorClass = "no_credentials"
+	ClassDNSFailure        ErrorClass = "dns_failure"
+	ClassTCPTimeout        ErrorClass = "tcp_timeout"
+	ClassConnectionRefused ErrorClass = "connection_refused"
+	ClassNetworkError      ErrorClass = "network_error"
+	ClassAuthRejected      ErrorClass = "auth_rejected"
+	ClassHostKeyMismatch   ErrorClass = "host_key_mismatch"
+	ClassHostKeyUnknown    ErrorClass = "host_key_unknown"
+	ClassSessionFailure    ErrorClass = "session_failure"
+	ClassCommandNotFound   ErrorClass = "command_not_found"
+	ClassCommandTimeout    ErrorClass = "command_timeout"
+	ClassCommandFailed     ErrorClass = "command_failed"
+	ClassOutputTooLarge    ErrorClass = "output_too_large"
+)
+
+// maxErrorDetail is the length of display_status.error_detail
+const maxErrorDetail = 1024
+
+// PollError is a poll failure with its class
+type PollError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *PollError) Error() string {
+	return string(e.Class) + ": " + e.Err.Error()
+}
+
+func newPollError(class ErrorClass, err error) *PollError {
+	return &PollError{Class: class, Err: err}
+}
+
+// Status returns the display_status.status text for the failure, the texts
+// the APIs already know
+func (e *PollError) Status() string {
+	switch e.Class {
+	case ClassHostKeyMismatch:
+		return "Host Key Mismatch"
+	case ClassHostKeyUnknown:
+		return "Unknown Host Key"
+	case ClassCommandTimeout:
+		return "Command Timeout"
+	case ClassCommandNotFound, ClassCommandFailed, ClassOutputTooLarge:
+		return "Failed to Execute Command"
+	default:
+		return "Failed to Connect"
+	}
+}
+
+// Detail returns the error text stored in display_status.error_detail
+func (e *PollError) Detail() string {
+	return truncateUTF8(e.Err.Error(), maxErrorDetail)
+}
+
+// truncateUTF8 cuts s to at most max bytes without splitting a character
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// classifyDialError maps an ssh.Dial error to its class
+func classifyDialError(err error) *PollError {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+
+	switch {
+	case isHostKeyMismatch(err):
+		return newPollError(ClassHostKeyMismatch, err)
+	case isHostKeyUnknown(err):
+		return newPollError(ClassHostKeyUnknown, err)
+	case errors.As(err, &dnsErr):
+		return newPollError(ClassDNSFailure, err)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return newPollError(ClassConnectionRefused, err)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return newPollError(ClassTCPTimeout, err)
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		// x/crypto/ssh has no typed error for rejected credentials
+		return newPollError(ClassAuthRejected, err)
+	default:
+		return newPollError(ClassNetworkError, err)
+	}
+}
+
+// classifyCommandError maps a failed remote command to its class
+func classifyCommandError(err error) *PollError {
+	var exitErr *ssh.ExitError
+
+	switch {
+	case errors.Is(err, errCommandTimeout):
+		return newPollError(ClassCommandTimeout, err)
+	case errors.Is(err, errOutputTooLarge):
+		return newPollError(ClassOutputTooLarge, err)
+	case errors.As(err, &exitErr) && exitErr.ExitStatus() == 127:
+		// The shell's "command not found" exit code
+		return newPollError(ClassCommandNotFound, err)
+	case errors.As(err, &exitErr):
+		return newPollError(ClassCommandFailed, err)
+	default:
+		// Channel closed or no exit status, the session itself broke
+		return newPollError(ClassSessionFailure, err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyConfig controls how unit host keys are verified
+type HostKeyConfig struct {
+	KnownHostsFile string `json:"known_hosts_file"` // OpenSSH known_hosts file, empty disables checking
+	TOFU           bool   `json:"tofu"`             // Record keys of hosts seen for the first time
+}
+
+// HostKeyStore checks host keys against a known_hosts file and, in TOFU mode,
+// appends keys of hosts it has not seen before.
+type HostKeyStore struct {
+	path string
+	tofu bool
+
+	mu       sync.Mutex
+	callback ssh.HostKeyCallback
+}
+
+func newHostKeyStore(cfg HostKeyConfig) (*HostKeyStore, error) {
+	store := &HostKeyStore{path: cfg.KnownHostsFile, tofu: cfg.TOFU}
+	if store.path == "" {
+		log.Println("No known_hosts_file configured, host keys are NOT verified")
+		return store, nil
+	}
+
+	// TOFU starts from an empty file; strict mode needs an existing one
+	if cfg.TOFU {
+		f, err := os.OpenFile(store.path, os.O_CREATE|os.O_RDONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create known_hosts file: %v", err)
+		}
+		f.Close()
+	}
+
+	err := store.reload()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *HostKeyStore) reload() error {
+	callback, err := knownhosts.New(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to load known_hosts file: %v", err)
+	}
+	s.callback = callback
+	return nil
+}
+
+// Callback returns the ssh.HostKeyCallback to use for every dial
+func (s *HostKeyStore) Callback() ssh.HostKeyCallback {
+	if s.path == "" {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	return s.check
+}
+
+func (s *HostKeyStore) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.callback(hostname, remote, key)
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 || !s.tofu {
+		return err
+	}
+
+	// Unknown host in TOFU mode: trust and remember this key
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts file: %v", err)
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	if err != nil {
+		return fmt.Errorf("failed to record host key: %v", err)
+	}
+	log.Printf("Recorded new %s host key for %s", key.Type(), hostname)
+
+	return s.reload()
+}
+
+// isHostKeyMismatch reports whether err means a unit presented a different key
+// than the one on record, as opposed to simply being unknown.
+func isHostKeyMismatch(err error) bool {
+	var keyErr *knownhosts.KeyError
+	return errors.As(err, &keyErr) && len(keyErr.Want) > 0
+}
+
+// isHostKeyUnknown reports whether err means the unit is not in known_hosts
+// and TOFU is off.
+func isHostKeyUnknown(err error) bool {
+	var keyErr *knownhosts.KeyError
+	return errors.As(err, &keyErr) && len(keyErr.Want) == 0
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// migrationFiles holds the schema as numbered SQL files,
+// migrations/NNNN_name.up.sql and an optional NNNN_name.down.sql. They are
+// the files of autoupdate_netstat/migrations/mysql: both collectors write
+// the same tables and record their migrations in the same schema_migrations,
+// so a change to one set must be copied to the other.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // Empty when the migration cannot be reverted
+}
+
+// loadMigrations reads the embedded migrations, in version order
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var base string
+		up := strings.HasSuffix(name, ".up.sql")
+		switch {
+		case up:
+			base = strings.TrimSuffix(name, ".up.sql")
+		case strings.HasSuffix(name, ".down.sql"):
+			base = strings.TrimSuffix(name, ".down.sql")
+		default:
+			continue
+		}
+
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("bad migration file name %s", name)
+		}
+		data, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if up {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements cuts a migration file into statements at semicolons that
+// end a line. Comment lines are dropped.
+func splitStatements(sql string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// appliedMigrations returns the applied migrations by version with their time
+func appliedMigrations(db *sql.DB) (map[int]int64, error) {
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]int64)
+	for rows.Next() {
+		var version int
+		var at int64
+		err := rows.Scan(&version, &at)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// migrateUp applies every pending migration
+func migrateUp(db *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INT PRIMARY KEY,
+        name VARCHAR(255),
+        applied_at BIGINT
+    )`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		log.Printf("Applying migration %04d %s", m.Version, m.Name)
+		err := runMigration(db, m.Up, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().Unix())
+		if err != nil {
+			return fmt.Errorf("migration %04d %s failed: %v", m.Version, m.Name, err)
+		}
+		if m.Version == 1 {
+			err = addLegacyColumns(db)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// legacyColumns are the columns the baseline has that tables created before
+// versioned migrations, by either collector, may lack
+var legacyColumns = []struct {
+	table, name, definition string
+}{
+	{"display_status", "poll_id", "VARCHAR(32)"},
+	{"display_status", "error_class", "VARCHAR(64)"},
+	{"display_status", "error_detail", "VARCHAR(1024)"},
+	{"display_status", "attempts", "INT"},
+	{"display_status", "attempt_history", "TEXT"},
+	{"display_status", "foreign_name", "VARCHAR(255)"},
+	{"display_connections", "foreign_name", "VARCHAR(255)"},
+	{"display_targets", "poll_id", "VARCHAR(32)"},
+	{"display_targets", "foreign_name", "VARCHAR(255)"},
+	{"display_status", "state_counts", "TEXT"},
+	{"display_targets", "state_counts", "TEXT"},
+	{"display_status", "ssh_reachable", "BOOLEAN"},
+	{"display_status", "ssh_latency_ms", "DOUBLE"},
+	{"display_status", "reachability", "TEXT"},
+}
+
+// addLegacyColumns adds the legacy columns missing from tables the baseline
+// found already there
+func addLegacyColumns(db *sql.DB) error {
+	for _, col := range legacyColumns {
+		rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", col.name, col.table))
+		if err == nil {
+			rows.Close()
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.name, col.definition))
+		if err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %v", col.table, col.name, err)
+		}
+	}
+	return nil
+}
+
+// migrateDown reverts the latest applied migration
+func migrateDown(db *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return fmt.Errorf("no migrations applied: %v", err)
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return fmt.Errorf("migration %04d %s cannot be reverted", m.Version, m.Name)
+		}
+		log.Printf("Reverting migration %04d %s", m.Version, m.Name)
+		return runMigration(db, m.Down, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	return fmt.Errorf("no migrations applied")
+}
+
+// runMigration runs the statements of a migration file, then the statement
+// that records it. MySQL commits each DDL statement on its own, so a failed
+// migration may be left half done and must be fixed by hand.
+func runMigration(db *sql.DB, sqlText string, record string, args ...interface{}) error {
+	for _, stmt := range splitStatements(sqlText) {
+		_, err := db.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec(record, args...)
+	return err
+}
+
+// runMigrate is the "migrate up|down|status" command. It returns the exit code.
+func runMigrate(db *sql.DB, command string) int {
+	var err error
+	switch command {
+	case "up":
+		err = migrateUp(db)
+	case "down":
+		err = migrateDown(db)
+	case "status":
+		var migrations []Migration
+		migrations, err = loadMigrations()
+		if err != nil {
+			break
+		}
+		// Without schema_migrations nothing was ever migrated, everything is pending
+		applied, _ := appliedMigrations(db)
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, m := range migrations {
+			status := "pending"
+			if at := applied[m.Version]; at > 0 {
+				status = time.Unix(at, 0).Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", m.Version, m.Name, status)
+		}
+		err = tw.Flush()
+	default:
+		log.Printf("Unknown migrate command %q, use up, down or status", command)
+		return 2
+	}
+
+	if err != nil {
+		log.Printf("migrate %s failed: %v", command, err)
+		return 1
+	}
+	return 0
+}
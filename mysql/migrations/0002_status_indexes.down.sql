@@ -0,0 +1,2 @@
+DROP INDEX idx_display_status_status_time ON display_status;
+DROP INDEX idx_display_status_unit_time ON display_status;
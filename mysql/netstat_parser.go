@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Connection represents a single socket row parsed from netstat output
+type Connection struct {
+	Proto          string
+	RecvQ          int
+	SendQ          int
+	LocalAddress   string
+	LocalPort      string
+	ForeignAddress string
+	ForeignPort    string
+	State          string
+}
+
+// Foreign returns the foreign endpoint in the same host:port form netstat prints
+func (c Connection) Foreign() string {
+	return c.ForeignAddress + ":" + c.ForeignPort
+}
+
+// parseNetstat turns the "Active Internet connections" part of netstat output
+// into typed records. Header lines and unix domain sockets are skipped.
+func parseNetstat(output []byte) []Connection {
+	var connections []Connection
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text()) // Split by any whitespace
+		if len(parts) < 5 || !isInternetProto(parts[0]) {
+			continue
+		}
+
+		recvQ, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+		sendQ, err := strconv.Atoi(parts[2])
+		if err != nil {
+			continue
+		}
+
+		conn := Connection{
+			Proto: parts[0],
+			RecvQ: recvQ,
+			SendQ: sendQ,
+		}
+		conn.LocalAddress, conn.LocalPort = splitAddress(parts[3])
+		conn.ForeignAddress, conn.ForeignPort = splitAddress(parts[4])
+		if len(parts) > 5 {
+			conn.State = parts[5]
+		}
+
+		connections = append(connections, conn)
+	}
+
+	return connections
+}
+
+func isInternetProto(proto string) bool {
+	return strings.HasPrefix(proto, "tcp") || strings.HasPrefix(proto, "udp") || strings.HasPrefix(proto, "raw")
+}
+
+// splitAddress splits a netstat address column on its last colon, so IPv6
+// addresses such as "::1:22" keep their inner colons.
+func splitAddress(address string) (string, string) {
+	i := strings.LastIndex(address, ":")
+	if i < 0 {
+		return address, ""
+	}
+	return address[:i], address[i+1:]
+}
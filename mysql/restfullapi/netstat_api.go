@@ -30,19 +30,36 @@ func main() {
 
 		}
 	}(db)
+	err = checkSchema(db)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	http.HandleFunc("/data2", getData(db))
 	log.Fatal(http.ListenAndServe(":port", nil))
 }
 
+// shownStatuses are the display_status values served, every TCP state of the
+// master link plus the collector's failure texts. The query filters on the same list.
+var shownStatuses = map[string]bool{
+	"ESTABLISHED": true, "SYN_SENT": true, "SYN_RECV": true, "CLOSE_WAIT": true,
+	"FIN_WAIT1": true, "FIN_WAIT2": true, "LAST_ACK": true, "CLOSING": true, "TIME_WAIT": true,
+	"Failed to Connect": true, "Host Key Mismatch": true, "Unknown Host Key": true, "Command Timeout": true,
+	"Failed to Execute Command": true, "": true,
+}
+
 func getData(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Get the latest data for each different id_unit
+		// Get the latest data for each different id_unit. A collector recording
+		// changes only refreshes unit_latest.last_seen while the status holds,
+		// which is when the unit was last seen in it.
 		query := `
-			SELECT id, date_time, id_unit, ip_unit, foreign_address, status
-			FROM display_status
-			WHERE status IN ('SYN_SENT', 'ESTABLISHED', 'Failed to Connect', '')
-			ORDER BY date_time DESC;
+			SELECT ds.id, COALESCE(ul.last_seen, ds.date_time), ds.id_unit, ds.ip_unit, ds.foreign_address, ds.status
+			FROM display_status ds
+			LEFT JOIN unit_latest ul ON ul.id_unit = ds.id_unit AND ul.poll_id = ds.poll_id
+			WHERE ds.status IN ('ESTABLISHED', 'SYN_SENT', 'SYN_RECV', 'CLOSE_WAIT', 'FIN_WAIT1', 'FIN_WAIT2', 'LAST_ACK', 'CLOSING', 'TIME_WAIT',
+				'Failed to Connect', 'Host Key Mismatch', 'Unknown Host Key', 'Command Timeout', 'Failed to Execute Command', '')
+			ORDER BY ds.date_time DESC, ds.id DESC;
 		`
 		rows, err := db.Query(query)
 		if err != nil {
@@ -67,7 +84,7 @@ func getData(db *sql.DB) http.HandlerFunc {
 				return
 			}
 
-			if !seen[d.IDUnit] && (d.StatusID == "SYN_SENT" || d.StatusID == "ESTABLISHED" || d.StatusID == "Failed to Connect" || d.StatusID == "") {
+			if !seen[d.IDUnit] && shownStatuses[d.StatusID] {
 				if d.StatusID == "" {
 					d.StatusID = "Netstat not detect Master"
 				}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// requiredSchema is the lowest schema version these queries are written for.
+// Version 2 adds the display_status indexes the latest-status queries rely on.
+const requiredSchema = 2
+
+// requiredColumns are the tables and columns this API's queries read
+var requiredColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"display_status", []string{"id", "date_time", "poll_id", "id_unit", "ip_unit", "foreign_address", "status"}},
+	{"unit_latest", []string{"id_unit", "poll_id", "last_seen"}},
+}
+
+// checkSchema refuses a database the collector has not migrated far enough,
+// or whose tables lack what the queries read. The API only reads; "migrate
+// up" on the collector changes the schema.
+func checkSchema(db *sql.DB) error {
+	var version sql.NullInt64
+	err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("database has no schema version, run the collector's \"migrate up\": %v", err)
+	}
+	if version.Int64 < requiredSchema {
+		return fmt.Errorf("database schema is at version %d, need %d: run the collector's \"migrate up\"", version.Int64, requiredSchema)
+	}
+
+	for _, t := range requiredColumns {
+		rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", strings.Join(t.columns, ", "), t.table))
+		if err != nil {
+			return fmt.Errorf("table %s lacks columns this API reads (%s): %v", t.table, strings.Join(t.columns, ", "), err)
+		}
+		rows.Close()
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
-	"bytes"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -13,15 +16,46 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-// Server represents a remote server with its IP address, username, password, and alias
+const (
+	commandTimeout = 30 * time.Second // Deadline for netstat on a unit
+	maxOutputBytes = 4 << 20          // Cap on captured netstat output
+)
+
+// Server represents a remote server with its IP address and alias
 type Server struct {
-	IP       string
-	Username string
-	Password string
-	Alias    string
+	IP    string
+	Alias string
 }
 
 func main() {
+	configPath := flag.String("config", "config.json", "Path to the collector config file")
+	flag.Parse()
+
+	// Load collector config and build the target matchers
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	matchers, err := newMatchers(cfg.Matchers)
+	if err != nil {
+		log.Fatalf("Invalid matcher config: %v", err)
+	}
+	groups, err := newUnitGroups(cfg.Groups)
+	if err != nil {
+		log.Fatalf("Invalid group config: %v", err)
+	}
+	if cfg.CredentialsFile == "" {
+		log.Fatal("credentials_file must be set in the config")
+	}
+	creds, err := loadCredentials(cfg.CredentialsFile, groups, "", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	hostKeys, err := newHostKeyStore(cfg.HostKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Open MySQL database
 	db, err := sql.Open("mysql", "username:password@tcp(ip:3306)/db_name")
 	if err != nil {
@@ -34,22 +68,21 @@ func main() {
 		}
 	}(db)
 
-	// Create server data table if not exists
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS display_status (
-        id INT AUTO_INCREMENT PRIMARY KEY,
-		date_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-        id_unit VARCHAR(255),
-        ip_unit VARCHAR(255),
-        foreign_address VARCHAR(255),
-        status VARCHAR(255)
-    );`)
+	// "migrate up|down|status" manages the schema and exits; any other run
+	// applies pending migrations first
+	if flag.Arg(0) == "migrate" {
+		code := runMigrate(db, flag.Arg(1))
+		db.Close()
+		os.Exit(code)
+	}
+	err = migrateUp(db)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// List of servers with their IP addresses, usernames, passwords, and aliases
+	// List of servers with their IP addresses and aliases, logins come from the credentials file
 	servers := []Server{
-		{"IP", "User", "Pass", "ID_unit"},
+		{"IP", "ID_unit"},
 		// Add more servers as needed
 	}
 
@@ -59,36 +92,58 @@ func main() {
 	for _, server := range servers {
 		go func(server Server) {
 			defer wg.Done()
-			connectToServer(db, server)
+			connectToServer(db, server, matchers, creds, hostKeys)
 		}(server)
 	}
 
 	wg.Wait()
 }
 
-func connectToServer(db *sql.DB, server Server) {
+func connectToServer(db *sql.DB, server Server, matchers []*Matcher, creds *Credentials, hostKeys *HostKeyStore) {
+	credential, err := creds.Resolve(server.Alias, server.IP)
+	if err != nil {
+		log.Printf("No usable credentials for %s (%s): %v", server.Alias, server.IP, err)
+		insertFailure(db, server, newPollError(ClassNoCredentials, err))
+		return
+	}
+
 	var maxRetries = 1
 	var retryCount = 0
 
 	for {
 		fmt.Printf("Connecting to %s (%s)...\n", server.Alias, server.IP)
 
-		// SSH connection configuration with password authentication
+		auth, closeAuth, err := credential.AuthMethods()
+		if err != nil {
+			log.Printf("Failed to prepare auth for %s (%s): %v", server.Alias, server.IP, err)
+			insertFailure(db, server, newPollError(ClassNoCredentials, err))
+			return
+		}
+
+		// SSH connection configuration with the unit's resolved credential
 		config := &ssh.ClientConfig{
-			User: server.Username,
-			Auth: []ssh.AuthMethod{
-				ssh.Password(server.Password),
-			},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(), // Use only in testing environments
+			User:            credential.Username,
+			Auth:            auth,
+			HostKeyCallback: hostKeys.Callback(),
 		}
 
 		// Connect to the remote server
 		client, err := ssh.Dial("tcp", server.IP+":22", config)
+		closeAuth()
 		if err != nil {
+			pollErr := classifyDialError(err)
+			switch pollErr.Class {
+			case ClassHostKeyMismatch, ClassHostKeyUnknown, ClassAuthRejected:
+				// Never retry or ignore a changed key, the unit may have been
+				// swapped or spoofed; retried logins lock accounts on some units
+				log.Printf("Not retrying %s (%s): %v\n", server.Alias, server.IP, pollErr)
+				insertFailure(db, server, pollErr)
+				return
+			}
 			log.Printf("Failed to dial to %s (%s): %v\n", server.Alias, server.IP, err)
 			retryCount++
 			if retryCount >= maxRetries {
-				insertDataToDatabase(db, server, "", "Failed to Connect")
+				insertFailure(db, server, pollErr)
 				return
 			}
 			time.Sleep(5 * time.Second) // Wait for 5 seconds before retrying
@@ -99,30 +154,31 @@ func connectToServer(db *sql.DB, server Server) {
 		session, err := client.NewSession()
 		if err != nil {
 			log.Printf("Failed to create session for %s (%s): %v\n", server.Alias, server.IP, err)
-			err := client.Close()
-			if err != nil {
-				return
-			}
+			client.Close()
 			retryCount++
 			if retryCount >= maxRetries {
-				insertDataToDatabase(db, server, "", "Failed to Connect")
+				insertFailure(db, server, newPollError(ClassSessionFailure, err))
 				return
 			}
 			time.Sleep(5 * time.Second) // Wait for 5 seconds before retrying
 			continue
 		}
 
-		// Execute the netstat command
-		output, err := session.CombinedOutput("netstat")
+		// Execute the netstat command, bounded in time and output size
+		output, err := runCommand(session, "netstat", commandTimeout, maxOutputBytes)
 		if err != nil {
-			log.Printf("Failed to execute command on %s (%s): %v\n", server.Alias, server.IP, err)
-			err := client.Close()
-			if err != nil {
+			pollErr := classifyCommandError(err)
+			log.Printf("Failed to execute command on %s (%s): %v\n", server.Alias, server.IP, pollErr)
+			client.Close()
+			switch pollErr.Class {
+			case ClassCommandTimeout, ClassCommandNotFound, ClassOutputTooLarge:
+				// Running it again would end the same way
+				insertFailure(db, server, pollErr)
 				return
 			}
 			retryCount++
 			if retryCount >= maxRetries {
-				insertDataToDatabase(db, server, "", "")
+				insertFailure(db, server, pollErr)
 				return
 			}
 			time.Sleep(5 * time.Second) // Wait for 5 seconds before retrying
@@ -130,44 +186,66 @@ func connectToServer(db *sql.DB, server Server) {
 		}
 
 		// Close session and client
-		err = session.Close()
-		if err != nil {
-			return
-		}
-		err = client.Close()
-		if err != nil {
-			return
-		}
+		session.Close()
+		client.Close()
 
-		// Process the output to find lines containing "master" in foreign address column
-		var foreignAddress, statusOutput string
-		lines := bytes.Split(output, []byte("\n"))
-		for _, line := range lines {
-			if strings.Contains(string(line), "master") {
-				parts := strings.Split(string(line), " ")
-				for _, part := range parts {
-					if strings.Contains(part, "master") {
-						foreignAddress = part
-					} else if part == "ESTABLISHED" || part == "SYN_SENT" {
-						statusOutput = part
-					}
-				}
-				break
-			}
-		}
+		// Resolve each configured target; the first matcher is the primary link
+		targets := matchTargets(matchers, parseNetstat(output))
+		primary := targets[0]
 
-		// Store data in SQLite database
-		insertDataToDatabase(db, server, foreignAddress, statusOutput)
+		// Store data in the database
+		pollID := insertDataToDatabase(db, server, primary.ForeignAddress, primary.Status, nil)
+		if pollID != "" {
+			insertTargetsToDatabase(db, pollID, server, targets)
+		}
 
 		return
 	}
 }
 
-func insertDataToDatabase(db *sql.DB, server Server, foreignAddress, statusOutput string) {
-	_, err := db.Exec("INSERT INTO display_status (id_unit, ip_unit, foreign_address, status) VALUES (?, ?, ?, ?)", server.Alias, server.IP, foreignAddress, statusOutput)
+// insertFailure stores a failed poll with its status text, class and detail
+func insertFailure(db *sql.DB, server Server, pollErr *PollError) {
+	insertDataToDatabase(db, server, "", pollErr.Status(), pollErr)
+}
+
+// insertDataToDatabase stores one poll row, with the failure that ended the
+// poll if any, and returns the poll id that links it to its display_targets
+// rows, or "" if the insert failed
+func insertDataToDatabase(db *sql.DB, server Server, foreignAddress, statusOutput string, pollErr *PollError) string {
+	var errorClass, errorDetail interface{}
+	if pollErr != nil {
+		errorClass, errorDetail = string(pollErr.Class), pollErr.Detail()
+	}
+	pollID := newPollID()
+	_, err := db.Exec("INSERT INTO display_status (poll_id, id_unit, ip_unit, foreign_address, status, error_class, error_detail) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		pollID, server.Alias, server.IP, foreignAddress, statusOutput, errorClass, errorDetail)
 	if err != nil {
 		log.Printf("Failed to insert data for %s (%s) into database: %v\n", server.Alias, server.IP, err)
-	} else {
-		log.Printf("Data inserted successfully for %s (%s) into database\n", server.Alias, server.IP)
+		return ""
+	}
+	log.Printf("Data inserted successfully for %s (%s) into database\n", server.Alias, server.IP)
+	return pollID
+}
+
+func insertTargetsToDatabase(db *sql.DB, pollID string, server Server, targets []TargetResult) {
+	placeholders := make([]string, 0, len(targets))
+	args := make([]interface{}, 0, len(targets)*5)
+	for _, t := range targets {
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?)")
+		args = append(args, pollID, server.Alias, t.Target, t.ForeignAddress, t.Status)
+	}
+
+	query := fmt.Sprintf("INSERT INTO display_targets (poll_id, id_unit, target, foreign_address, status) VALUES %s", strings.Join(placeholders, ", "))
+	_, err := db.Exec(query, args...)
+	if err != nil {
+		log.Printf("Failed to insert targets for %s (%s) into database: %v\n", server.Alias, server.IP, err)
 	}
 }
+
+// newPollID returns a random id that ties a display_status row to its
+// display_targets rows, as the main collector does
+func newPollID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"flag"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -11,6 +12,8 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
 type Data struct {
@@ -20,6 +23,8 @@ type Data struct {
 	IPUnit      string `json:"ip_unit"`
 	ForeignAddr string `json:"foreign_address"`
 	StatusID    string `json:"status"`
+	ErrorClass  string `json:"error_class"` // Why the last poll failed, empty on success
+	Polling     string `json:"polling"`     // "suspended polling" while the collector's circuit breaker is open
 }
 
 type ExternalAPIResponse struct {
@@ -33,12 +38,20 @@ type ExternalAPIResponse struct {
 var ctx = context.Background()
 
 func main() {
+	driver := flag.String("driver", "mysql", "Database driver: mysql, postgres or sqlite")
+	dsn := flag.String("dsn", "username:password@tcp(IP:3306)/db_name", "Database connection string")
+	flag.Parse()
+
 	// Set up the database connection
-	db, err := sql.Open("mysql", "username:password@tcp(IP:3306)/db_name")
+	db, err := sql.Open(*driver, *dsn)
 	if err != nil {
 		log.Fatalf("Failed to open database connection: %v", err)
 	}
 	defer db.Close()
+	err = checkSchema(db)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Set up Redis connection
 	rdb := redis.NewClient(&redis.Options{
@@ -116,7 +129,7 @@ func fetchDataFromDBAndAPI(db *sql.DB) ([]Data, error) {
 
 	for rows.Next() {
 		var d Data
-		err := rows.Scan(&d.ID, &d.DateTime, &d.IDUnit, &d.IPUnit, &d.ForeignAddr, &d.StatusID)
+		err := rows.Scan(&d.ID, &d.DateTime, &d.IDUnit, &d.IPUnit, &d.ForeignAddr, &d.StatusID, &d.ErrorClass, &d.Polling)
 		if err != nil {
 			return nil, err
 		}
@@ -138,35 +151,43 @@ func getQuery() string {
         WITH LastEstablished AS (
             SELECT id_unit, MAX(date_time) AS last_established
             FROM display_status
-            WHERE status = 'established'
+            WHERE status = 'ESTABLISHED'
             GROUP BY id_unit
         ),
         RankedSynSent AS (
-            SELECT ds.id, ds.date_time, ds.id_unit, ds.ip_unit, ds.foreign_address, ds.status,
-                       ROW_NUMBER() OVER (PARTITION BY ds.id_unit ORDER BY ds.date_time) AS rn
+            SELECT ds.id, ds.date_time, ds.id_unit, ds.ip_unit, ds.foreign_address, ds.status, COALESCE(ds.error_class, '') AS error_class,
+                       ROW_NUMBER() OVER (PARTITION BY ds.id_unit ORDER BY ds.date_time, ds.id) AS rn
             FROM display_status ds
             INNER JOIN LastEstablished le ON ds.id_unit = le.id_unit
-            WHERE ds.date_time > le.last_established AND ds.status = 'syn_sent'
+            WHERE ds.date_time > le.last_established AND ds.status = 'SYN_SENT'
         ),
         FirstSynSent AS (
-            SELECT id, date_time, id_unit, ip_unit, foreign_address, status
+            SELECT id, date_time, id_unit, ip_unit, foreign_address, status, error_class
             FROM RankedSynSent
             WHERE rn = 1
         ),
         RankedLatestStatus AS (
-            SELECT ds.id, ds.date_time, ds.id_unit, ds.ip_unit, ds.foreign_address, ds.status,
-                       ROW_NUMBER() OVER (PARTITION BY ds.id_unit ORDER BY ds.date_time DESC) AS rn
+            SELECT ds.id, COALESCE(ul.last_seen, ds.date_time) AS date_time, ds.id_unit, ds.ip_unit, ds.foreign_address, ds.status, COALESCE(ds.error_class, '') AS error_class,
+                       ROW_NUMBER() OVER (PARTITION BY ds.id_unit ORDER BY ds.date_time DESC, ds.id DESC) AS rn
             FROM display_status ds
+            LEFT JOIN unit_latest ul ON ul.id_unit = ds.id_unit AND ul.poll_id = ds.poll_id
         ),
         LatestStatus AS (
-            SELECT id, date_time, id_unit, ip_unit, foreign_address, status
+            SELECT id, date_time, id_unit, ip_unit, foreign_address, status, error_class
             FROM RankedLatestStatus
             WHERE rn = 1
+        ),
+        Suspended AS (
+            SELECT id_unit
+            FROM unit_breakers
+            WHERE state = 'open'
         )
-        SELECT id, date_time, id_unit, ip_unit, foreign_address, status
+        SELECT id, date_time, id_unit, ip_unit, foreign_address, status, error_class,
+            CASE WHEN id_unit IN (SELECT id_unit FROM Suspended) THEN 'suspended polling' ELSE 'active' END AS polling
         FROM FirstSynSent
         UNION
-        SELECT id, date_time, id_unit, ip_unit, foreign_address, status
+        SELECT id, date_time, id_unit, ip_unit, foreign_address, status, error_class,
+            CASE WHEN id_unit IN (SELECT id_unit FROM Suspended) THEN 'suspended polling' ELSE 'active' END AS polling
         FROM LatestStatus
         WHERE id_unit NOT IN (SELECT id_unit FROM FirstSynSent)
         ORDER BY id_unit, date_time;
@@ -3,10 +3,16 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"flag"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
 type Data struct {
@@ -16,11 +22,32 @@ type Data struct {
 	IPUnit      string `json:"ip_unit"`
 	ForeignAddr string `json:"foreign_address"`
 	StatusID    string `json:"status"`
+	ErrorClass  string `json:"error_class"` // Why the last poll failed, empty on success
+	Polling     string `json:"polling"`     // "suspended polling" while the collector's circuit breaker is open
 }
 
+// Transition is one status_transitions row written by the collector
+type Transition struct {
+	ID              int    `json:"id"`
+	IDUnit          string `json:"id_unit"`
+	From            string `json:"from_status"`
+	To              string `json:"to_status"`
+	ChangedAt       string `json:"changed_at"`
+	DurationSeconds *int64 `json:"duration_seconds"` // Time spent in from_status, null when unknown
+}
+
+const (
+	defaultTransitionLimit = 100
+	maxTransitionLimit     = 10000
+)
+
 func main() {
+	driver := flag.String("driver", "mysql", "Database driver: mysql, postgres or sqlite")
+	dsn := flag.String("dsn", "username:password@tcp(127.0.0.1:3306)/db_name", "Database connection string")
+	flag.Parse()
+
 	// Set up the database connection
-	db, err := sql.Open("mysql", "username:password@tcp(127.0.0.1:3306)/db_name")
+	db, err := sql.Open(*driver, *dsn)
 	if err != nil {
 		log.Fatalf("Failed to open database connection: %v", err)
 	}
@@ -30,8 +57,13 @@ func main() {
 			log.Printf("Error closing database connection: %v", err)
 		}
 	}(db)
+	err = checkSchema(db)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	http.HandleFunc("/data2", getData(db))
+	http.HandleFunc("/transitions", getTransitions(db, *driver))
 	log.Fatal(http.ListenAndServe(":port", nil))
 }
 
@@ -43,35 +75,43 @@ func getData(db *sql.DB) http.HandlerFunc {
 			WITH LastEstablished AS (
 				SELECT id_unit, MAX(date_time) AS last_established
 				FROM display_status
-				WHERE status = 'established'
+				WHERE status = 'ESTABLISHED'
 				GROUP BY id_unit
 			),
 			RankedSynSent AS (
-				SELECT ds.id, ds.date_time, ds.id_unit, ds.ip_unit, ds.foreign_address, ds.status,
-					   ROW_NUMBER() OVER (PARTITION BY ds.id_unit ORDER BY ds.date_time) AS rn
+				SELECT ds.id, ds.date_time, ds.id_unit, ds.ip_unit, ds.foreign_address, ds.status, COALESCE(ds.error_class, '') AS error_class,
+					   ROW_NUMBER() OVER (PARTITION BY ds.id_unit ORDER BY ds.date_time, ds.id) AS rn
 				FROM display_status ds
 				INNER JOIN LastEstablished le ON ds.id_unit = le.id_unit
-				WHERE ds.date_time > le.last_established AND ds.status = 'syn_sent'
+				WHERE ds.date_time > le.last_established AND ds.status = 'SYN_SENT'
 			),
 			FirstSynSent AS (
-				SELECT id, date_time, id_unit, ip_unit, foreign_address, status
+				SELECT id, date_time, id_unit, ip_unit, foreign_address, status, error_class
 				FROM RankedSynSent
 				WHERE rn = 1
 			),
 			RankedLatestStatus AS (
-				SELECT ds.id, ds.date_time, ds.id_unit, ds.ip_unit, ds.foreign_address, ds.status,
-					   ROW_NUMBER() OVER (PARTITION BY ds.id_unit ORDER BY ds.date_time DESC) AS rn
+				SELECT ds.id, COALESCE(ul.last_seen, ds.date_time) AS date_time, ds.id_unit, ds.ip_unit, ds.foreign_address, ds.status, COALESCE(ds.error_class, '') AS error_class,
+					   ROW_NUMBER() OVER (PARTITION BY ds.id_unit ORDER BY ds.date_time DESC, ds.id DESC) AS rn
 				FROM display_status ds
+				LEFT JOIN unit_latest ul ON ul.id_unit = ds.id_unit AND ul.poll_id = ds.poll_id
 			),
 			LatestStatus AS (
-				SELECT id, date_time, id_unit, ip_unit, foreign_address, status
+				SELECT id, date_time, id_unit, ip_unit, foreign_address, status, error_class
 				FROM RankedLatestStatus
 				WHERE rn = 1
+			),
+			Suspended AS (
+				SELECT id_unit
+				FROM unit_breakers
+				WHERE state = 'open'
 			)
-			SELECT id, date_time, id_unit, ip_unit, foreign_address, status
+			SELECT id, date_time, id_unit, ip_unit, foreign_address, status, error_class,
+				CASE WHEN id_unit IN (SELECT id_unit FROM Suspended) THEN 'suspended polling' ELSE 'active' END AS polling
 			FROM FirstSynSent
 			UNION
-			SELECT id, date_time, id_unit, ip_unit, foreign_address, status
+			SELECT id, date_time, id_unit, ip_unit, foreign_address, status, error_class,
+				CASE WHEN id_unit IN (SELECT id_unit FROM Suspended) THEN 'suspended polling' ELSE 'active' END AS polling
 			FROM LatestStatus
 			WHERE id_unit NOT IN (SELECT id_unit FROM FirstSynSent)
 			ORDER BY id_unit, date_time;
@@ -96,7 +136,7 @@ func getData(db *sql.DB) http.HandlerFunc {
 		log.Println("Processing query results")
 		for rows.Next() {
 			var d Data
-			err := rows.Scan(&d.ID, &d.DateTime, &d.IDUnit, &d.IPUnit, &d.ForeignAddr, &d.StatusID)
+			err := rows.Scan(&d.ID, &d.DateTime, &d.IDUnit, &d.IPUnit, &d.ForeignAddr, &d.StatusID, &d.ErrorClass, &d.Polling)
 			if err != nil {
 				log.Printf("Error scanning row: %v", err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -131,3 +171,83 @@ func getData(db *sql.DB) http.HandlerFunc {
 		log.Println("Response successfully written")
 	}
 }
+
+// getTransitions lists status changes, newest first, for the whole fleet or
+// for the unit given with ?unit=. ?limit= caps the number of rows.
+func getTransitions(db *sql.DB, driver string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultTransitionLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > maxTransitionLimit {
+				http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxTransitionLimit), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		query := `
+			SELECT id, id_unit, from_status, to_status, changed_at, duration_seconds
+			FROM status_transitions`
+		var args []interface{}
+		if unit := r.URL.Query().Get("unit"); unit != "" {
+			query += " WHERE id_unit = ?"
+			args = append(args, unit)
+		}
+		query += " ORDER BY id DESC LIMIT " + strconv.Itoa(limit)
+		if driver == "postgres" {
+			query = strings.Replace(query, "?", "$1", 1)
+		}
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			log.Printf("Error executing query: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer func(rows *sql.Rows) {
+			err := rows.Close()
+			if err != nil {
+				log.Printf("Error closing rows: %v", err)
+			}
+		}(rows)
+
+		transitions := []Transition{}
+		for rows.Next() {
+			var t Transition
+			var changedAt int64
+			var duration sql.NullInt64
+			err := rows.Scan(&t.ID, &t.IDUnit, &t.From, &t.To, &changedAt, &duration)
+			if err != nil {
+				log.Printf("Error scanning row: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			t.ChangedAt = time.Unix(changedAt, 0).Format(time.RFC3339)
+			if duration.Valid {
+				t.DurationSeconds = &duration.Int64
+			}
+			transitions = append(transitions, t)
+		}
+
+		err = rows.Err()
+		if err != nil {
+			log.Printf("Error iterating rows: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonData, err := json.Marshal(transitions)
+		if err != nil {
+			log.Printf("Error marshaling JSON: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(jsonData)
+		if err != nil {
+			log.Printf("Error writing response: %v", err)
+		}
+	}
+}